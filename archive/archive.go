@@ -0,0 +1,212 @@
+// Package archive reads and writes postshortly export archives.
+//
+// An archive is newline delimited JSON. The first line is a header, every
+// following line holds one post, and the last line is a manifest carrying
+// the number of posts and the SHA-256 of the post lines:
+//
+//	{"type":"header","format":"postshortly-archive","version":1,"created":1700000000}
+//	{"type":"post","post":{"id":1,"timestamp":...,"body":"...","pubkey":"...","signature":"..."}}
+//	{"type":"manifest","count":1,"sha256":"..."}
+//
+// Posts keep their pubkey and signature, so an archive stays verifiable
+// wherever it ends up. The hash only protects against truncation and
+// accidental damage; authenticity comes from the signatures.
+package archive
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/donuts-are-good/postshortly/sdk"
+)
+
+const (
+	Format  = "postshortly-archive"
+	Version = 1
+
+	// MaxLineSize bounds a single archive line; posts are far smaller.
+	MaxLineSize = 64 * 1024
+)
+
+var (
+	ErrFormat   = errors.New("not a postshortly archive")
+	ErrVersion  = errors.New("unsupported archive version")
+	ErrManifest = errors.New("archive manifest does not match its contents")
+	ErrTruncate = errors.New("archive is truncated")
+)
+
+type Header struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+	Created int64  `json:"created"`
+}
+
+type Manifest struct {
+	Count  int    `json:"count"`
+	SHA256 string `json:"sha256"`
+}
+
+type line struct {
+	Type string            `json:"type"`
+	Post *sdk.StatusUpdate `json:"post,omitempty"`
+	Header
+	Manifest
+}
+
+// Writer writes an archive. Close must be called to write the manifest.
+type Writer struct {
+	w     io.Writer
+	hash  hash.Hash
+	count int
+}
+
+// NewWriter writes the archive header to w.
+func NewWriter(w io.Writer) (*Writer, error) {
+	aw := &Writer{w: w, hash: sha256.New()}
+	header := struct {
+		Type string `json:"type"`
+		Header
+	}{"header", Header{Format: Format, Version: Version, Created: time.Now().Unix()}}
+
+	if err := aw.writeLine(header, false); err != nil {
+		return nil, err
+	}
+	return aw, nil
+}
+
+// Write appends a post to the archive.
+func (aw *Writer) Write(post sdk.StatusUpdate) error {
+	err := aw.writeLine(struct {
+		Type string           `json:"type"`
+		Post sdk.StatusUpdate `json:"post"`
+	}{"post", post}, true)
+	if err == nil {
+		aw.count++
+	}
+	return err
+}
+
+// Close writes the manifest. It does not close the underlying writer.
+func (aw *Writer) Close() error {
+	return aw.writeLine(struct {
+		Type string `json:"type"`
+		Manifest
+	}{"manifest", Manifest{Count: aw.count, SHA256: hex.EncodeToString(aw.hash.Sum(nil))}}, false)
+}
+
+func (aw *Writer) writeLine(v interface{}, hashed bool) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if hashed {
+		aw.hash.Write(data)
+	}
+	_, err = aw.w.Write(data)
+	return err
+}
+
+// Reader reads the posts of an archive.
+type Reader struct {
+	Header Header
+
+	scanner *bufio.Scanner
+	hash    hash.Hash
+	count   int
+	done    bool
+}
+
+// NewReader reads and checks the archive header.
+func NewReader(r io.Reader) (*Reader, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), MaxLineSize)
+
+	ar := &Reader{scanner: scanner, hash: sha256.New()}
+	l, _, err := ar.readLine()
+	if err != nil {
+		if err == ErrTruncate {
+			return nil, ErrFormat
+		}
+		return nil, err
+	}
+	if l.Type != "header" || l.Format != Format {
+		return nil, ErrFormat
+	}
+	if l.Version != Version {
+		return nil, fmt.Errorf("%w: %d", ErrVersion, l.Version)
+	}
+
+	ar.Header = l.Header
+	return ar, nil
+}
+
+// Next returns the next post. After the last post it checks the manifest
+// and returns io.EOF, or ErrManifest/ErrTruncate if the archive is damaged.
+func (ar *Reader) Next() (sdk.StatusUpdate, error) {
+	if ar.done {
+		return sdk.StatusUpdate{}, io.EOF
+	}
+
+	l, raw, err := ar.readLine()
+	if err != nil {
+		return sdk.StatusUpdate{}, err
+	}
+
+	switch l.Type {
+	case "post":
+		if l.Post == nil {
+			return sdk.StatusUpdate{}, fmt.Errorf("%w: post line without post", ErrFormat)
+		}
+		ar.hash.Write(raw)
+		ar.count++
+		return *l.Post, nil
+	case "manifest":
+		ar.done = true
+		if l.Count != ar.count || l.SHA256 != hex.EncodeToString(ar.hash.Sum(nil)) {
+			return sdk.StatusUpdate{}, ErrManifest
+		}
+		return sdk.StatusUpdate{}, io.EOF
+	default:
+		return sdk.StatusUpdate{}, fmt.Errorf("%w: unexpected %q line", ErrFormat, l.Type)
+	}
+}
+
+// ReadAll returns every post in the archive once the manifest has been
+// checked, so callers never act on a damaged archive.
+func (ar *Reader) ReadAll() ([]sdk.StatusUpdate, error) {
+	var posts []sdk.StatusUpdate
+	for {
+		post, err := ar.Next()
+		if err == io.EOF {
+			return posts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+}
+
+func (ar *Reader) readLine() (line, []byte, error) {
+	if !ar.scanner.Scan() {
+		if err := ar.scanner.Err(); err != nil {
+			return line{}, nil, err
+		}
+		return line{}, nil, ErrTruncate
+	}
+
+	raw := append(ar.scanner.Bytes(), '\n')
+	var l line
+	if err := json.Unmarshal(raw, &l); err != nil {
+		return line{}, nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	return l, raw, nil
+}
@@ -0,0 +1,82 @@
+package archive
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/donuts-are-good/postshortly/sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeArchive(t *testing.T, posts ...sdk.StatusUpdate) *bytes.Buffer {
+	var buf bytes.Buffer
+	aw, err := NewWriter(&buf)
+	assert.NoError(t, err)
+	for _, post := range posts {
+		assert.NoError(t, aw.Write(post))
+	}
+	assert.NoError(t, aw.Close())
+	return &buf
+}
+
+func TestRoundTrip(t *testing.T) {
+	_, priv, _ := sdk.GenerateKey()
+	first := sdk.StatusUpdate{ID: 1, Timestamp: 10, Body: "first"}
+	second := sdk.StatusUpdate{ID: 2, Timestamp: 20, Body: "second", Link: "http://example.com"}
+	sdk.SignStatusUpdate(priv, &first)
+	sdk.SignStatusUpdate(priv, &second)
+
+	ar, err := NewReader(writeArchive(t, first, second))
+	assert.NoError(t, err)
+	assert.Equal(t, Version, ar.Header.Version)
+
+	posts, err := ar.ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, []sdk.StatusUpdate{first, second}, posts)
+
+	_, err = ar.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestEmptyArchive(t *testing.T) {
+	ar, err := NewReader(writeArchive(t))
+	assert.NoError(t, err)
+
+	posts, err := ar.ReadAll()
+	assert.NoError(t, err)
+	assert.Empty(t, posts)
+}
+
+func TestTamperedArchive(t *testing.T) {
+	data := writeArchive(t, sdk.StatusUpdate{Body: "original"}).String()
+
+	ar, err := NewReader(strings.NewReader(strings.Replace(data, "original", "modified", 1)))
+	assert.NoError(t, err)
+
+	_, err = ar.ReadAll()
+	assert.ErrorIs(t, err, ErrManifest)
+}
+
+func TestTruncatedArchive(t *testing.T) {
+	data := writeArchive(t, sdk.StatusUpdate{Body: "first"}, sdk.StatusUpdate{Body: "second"}).String()
+	lines := strings.SplitAfter(data, "\n")
+
+	ar, err := NewReader(strings.NewReader(strings.Join(lines[:2], "")))
+	assert.NoError(t, err)
+
+	_, err = ar.ReadAll()
+	assert.ErrorIs(t, err, ErrTruncate)
+}
+
+func TestNotAnArchive(t *testing.T) {
+	_, err := NewReader(strings.NewReader(`[{"body":"hello"}]` + "\n"))
+	assert.ErrorIs(t, err, ErrFormat)
+
+	_, err = NewReader(strings.NewReader(""))
+	assert.ErrorIs(t, err, ErrFormat)
+
+	_, err = NewReader(strings.NewReader(`{"type":"header","format":"postshortly-archive","version":99}` + "\n"))
+	assert.ErrorIs(t, err, ErrVersion)
+}
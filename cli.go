@@ -0,0 +1,782 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/donuts-are-good/postshortly/sdk"
+	"github.com/donuts-are-good/postshortly/server"
+)
+
+const defaultKeyFile = "postshortly.key"
+
+const usage = `usage: postshortly <command> [flags]
+
+commands:
+  serve    run the API server (default)
+  keygen   generate a new ed25519 key pair
+  sign     sign a status update and print the JSON payload
+  post     sign a status update and send it to a server
+  feed     print the status updates on a server
+  verify   check the signatures of the status updates on a server or in
+           a local database file
+  export   write an archive of every post on a server or in a database
+  import   load an archive into a local database file
+  migrate  apply pending database schema migrations
+  backup   write a snapshot of a SQLite database while it is in use
+  restore  replace a SQLite database with a snapshot
+  top      show live statistics of a running server
+  dm       send an encrypted direct message to a pubkey
+  inbox    read and decrypt the direct messages sent to your key
+
+Run 'postshortly <command> -h' for the flags of a command.
+`
+
+func runCommand(args []string) error {
+	switch args[0] {
+	case "serve":
+		return serve(args[1:])
+	case "keygen":
+		return keygenCommand(args[1:])
+	case "sign":
+		return signCommand(args[1:])
+	case "post":
+		return postCommand(args[1:])
+	case "feed":
+		return feedCommand(args[1:])
+	case "verify":
+		return verifyCommand(args[1:])
+	case "export":
+		return exportCommand(args[1:])
+	case "import":
+		return importCommand(args[1:])
+	case "migrate":
+		return migrateCommand(args[1:])
+	case "backup":
+		return backupCommand(args[1:])
+	case "restore":
+		return restoreCommand(args[1:])
+	case "top":
+		return topCommand(args[1:])
+	case "dm":
+		return dmCommand(args[1:])
+	case "inbox":
+		return inboxCommand(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return nil
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func keygenCommand(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	out := fs.String("out", defaultKeyFile, "file to write the private key to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	pub, priv, err := sdk.GenerateKey()
+	if err != nil {
+		return fmt.Errorf("error generating key: %v", err)
+	}
+
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("error creating key file: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := fmt.Fprintln(f, sdk.EncodePrivateKey(priv)); err != nil {
+		return fmt.Errorf("error writing key file: %v", err)
+	}
+
+	fmt.Printf("Private key written to %s\n", *out)
+	fmt.Printf("Public key: %x\n", pub)
+	return nil
+}
+
+func signCommand(args []string) error {
+	fs := flag.NewFlagSet("sign", flag.ContinueOnError)
+	keyFile := fs.String("key", defaultKeyFile, "private key file")
+	body := fs.String("body", "", "status body")
+	link := fs.String("link", "", "optional link")
+	pow := fs.Int("pow", 0, "bits of proof of work to add")
+	var attachments []string
+	fs.Func("attach", "hash of uploaded media to attach (repeatable)", func(hash string) error {
+		attachments = append(attachments, hash)
+		return nil
+	})
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	update, err := signedUpdate(*keyFile, *body, *link, attachments...)
+	if err != nil {
+		return err
+	}
+	if err := sdk.SolveProofOfWork(context.Background(), &update, *pow); err != nil {
+		return err
+	}
+
+	return printJSON(update)
+}
+
+func postCommand(args []string) error {
+	fs := flag.NewFlagSet("post", flag.ContinueOnError)
+	serverURL := fs.String("server", sdk.DefaultServer, "server address")
+	keyFile := fs.String("key", defaultKeyFile, "private key file")
+	body := fs.String("body", "", "status body")
+	link := fs.String("link", "", "optional link")
+	var files []string
+	fs.Func("attach", "file to upload and attach (repeatable)", func(path string) error {
+		files = append(files, path)
+		return nil
+	})
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// Uploads are signed, so the client needs the key whenever there are
+	// files to attach.
+	ctx := context.Background()
+	client := sdk.NewClient(*serverURL)
+	var attachments []string
+	if len(files) > 0 {
+		uploader, err := newClient(*serverURL, *keyFile)
+		if err != nil {
+			return err
+		}
+		for _, path := range files {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("error reading attachment: %v", err)
+			}
+			var media sdk.Media
+			err = retryRateLimited(func() (err error) {
+				media, err = uploader.UploadMedia(ctx, data)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("error uploading %s: %v", path, err)
+			}
+			attachments = append(attachments, media.Hash)
+		}
+	}
+
+	update, err := signedUpdate(*keyFile, *body, *link, attachments...)
+	if err != nil {
+		return err
+	}
+
+	// Ask the server how much proof of work the post needs.
+	difficulty, err := client.ProofOfWorkDifficulty(ctx, update.Pubkey)
+	if err != nil {
+		return fmt.Errorf("error fetching proof of work difficulty: %v", err)
+	}
+	if err := sdk.SolveProofOfWork(ctx, &update, difficulty); err != nil {
+		return err
+	}
+
+	var created sdk.StatusUpdate
+	err = retryRateLimited(func() (err error) {
+		created, err = client.Post(ctx, update)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error posting status update: %v", err)
+	}
+
+	return printJSON(created)
+}
+
+// retryRateLimited calls fn again while the server answers that it is rate
+// limited, as it does when uploads and the post follow each other quickly.
+func retryRateLimited(fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		var apiErr *sdk.APIError
+		if attempt == 5 || !errors.As(err, &apiErr) || apiErr.Code != server.CodeRateLimited {
+			return err
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func feedCommand(args []string) error {
+	fs := flag.NewFlagSet("feed", flag.ContinueOnError)
+	serverURL := fs.String("server", sdk.DefaultServer, "server address")
+	pubkey := fs.String("pubkey", "", "only show posts by this public key")
+	asJSON := fs.Bool("json", false, "print the raw JSON feed")
+	signKey := fs.String("sign", "", "private key file to sign requests with, for private instances")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	client, err := newClient(*serverURL, *signKey)
+	if err != nil {
+		return err
+	}
+	updates, err := fetchFeed(client, *pubkey)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(updates)
+	}
+
+	for _, update := range updates {
+		fmt.Printf("%s  %s  %s", time.Unix(0, update.Timestamp).Format("2006-01-02 03:04:05 PM"), shortKey(update.Pubkey), update.Body)
+		if update.Link != "" {
+			fmt.Printf("  <%s>", update.Link)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+func verifyCommand(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	serverURL := fs.String("server", sdk.DefaultServer, "server address")
+	pubkey := fs.String("pubkey", "", "only verify posts by this public key")
+	in := fs.String("in", "", "verify posts from a JSON file instead of a server ('-' for stdin)")
+	dbPath := fs.String("db", "", "audit a database (SQLite file or postgres:// URL) offline instead of asking a server")
+	quarantine := fs.Bool("quarantine", false, "with -db, move posts that fail into quarantined_updates")
+	asJSON := fs.Bool("json", false, "with -db, print the report as JSON")
+	signKey := fs.String("sign", "", "private key file to sign requests with, for private instances")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *dbPath != "" {
+		return verifyDatabase(*dbPath, *quarantine, *asJSON)
+	}
+
+	var updates []sdk.StatusUpdate
+	var err error
+	if *in != "" {
+		updates, err = readUpdates(*in)
+	} else {
+		var client *sdk.Client
+		if client, err = newClient(*serverURL, *signKey); err != nil {
+			return err
+		}
+		updates, err = fetchFeed(client, *pubkey)
+	}
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, update := range updates {
+		if err := sdk.VerifyStatusUpdate(update); err != nil {
+			failed++
+			fmt.Printf("FAIL  id=%d pubkey=%s: %v\n", update.ID, update.Pubkey, err)
+		}
+	}
+
+	fmt.Printf("%d posts checked, %d failed\n", len(updates), failed)
+	if failed > 0 {
+		return fmt.Errorf("%d posts failed verification", failed)
+	}
+	return nil
+}
+
+func verifyDatabase(path string, quarantine, asJSON bool) error {
+	if err := checkDatabaseExists(path); err != nil {
+		return err
+	}
+
+	store, err := server.OpenStore(path, true)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	report, err := server.AuditStatusUpdates(store, quarantine)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		if err := printJSON(report); err != nil {
+			return err
+		}
+	} else {
+		for _, failure := range report.Failed {
+			fmt.Printf("FAIL  id=%d pubkey=%s: %s\n", failure.ID, failure.Pubkey, failure.Reason)
+		}
+		fmt.Printf("%d posts checked, %d failed, %d quarantined\n", report.Checked, len(report.Failed), report.Quarantined)
+	}
+
+	if len(report.Failed) > report.Quarantined {
+		return fmt.Errorf("%d posts failed verification", len(report.Failed)-report.Quarantined)
+	}
+	return nil
+}
+
+func exportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	serverURL := fs.String("server", sdk.DefaultServer, "server address")
+	dbPath := fs.String("db", "", "export a database (SQLite file or postgres:// URL) instead of asking a server")
+	out := fs.String("out", "-", "archive file to write ('-' for stdout)")
+	signKey := fs.String("sign", "", "private key file to sign requests with, for private instances")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return fmt.Errorf("error creating archive: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if *dbPath == "" {
+		client, err := newClient(*serverURL, *signKey)
+		if err != nil {
+			return err
+		}
+		return client.Export(context.Background(), w)
+	}
+
+	if err := checkDatabaseExists(*dbPath); err != nil {
+		return err
+	}
+	store, err := server.OpenStore(*dbPath, true)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	return server.ExportArchive(store, w)
+}
+
+func importCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	configPath := fs.String("config", "", "config whose access lists, bans and filters posts must pass")
+	dbPath := fs.String("db", "", "database to import into (SQLite file or postgres:// URL; defaults to the config's)")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: postshortly import [-config file] [-db file] <archive|->")
+	}
+
+	config := server.DefaultConfig()
+	if *configPath != "" {
+		var err error
+		if config, err = server.LoadConfig(*configPath); err != nil {
+			return err
+		}
+	}
+	if *dbPath != "" {
+		config.Database = *dbPath
+	}
+
+	var r io.Reader = os.Stdin
+	if path := fs.Arg(0); path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	store, err := server.OpenStore(config.Database, true)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	srv, err := server.New(config, store)
+	if err != nil {
+		return err
+	}
+	srv.SetLogOutput(nil, nil)
+	if err := srv.ReloadAccessLists(); err != nil {
+		return fmt.Errorf("error loading access lists: %v", err)
+	}
+	report, err := srv.ImportArchive(r)
+	if err != nil {
+		return fmt.Errorf("error importing archive: %v", err)
+	}
+
+	if *asJSON {
+		return printJSON(report)
+	}
+	for _, rejection := range report.Rejected {
+		fmt.Printf("REJECTED  pubkey=%s: %s\n", rejection.Pubkey, rejection.Reason)
+	}
+	fmt.Printf("%d imported, %d duplicates skipped, %d rejected\n", report.Imported, report.Duplicates, len(report.Rejected))
+	return nil
+}
+
+func migrateCommand(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dbPath := fs.String("db", server.DefaultConfig().Database, "database to migrate (SQLite file or postgres:// URL)")
+	status := fs.Bool("status", false, "only print the schema version")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	s, err := server.ConnectStore(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	current, latest, err := s.SchemaVersion()
+	if err != nil {
+		return fmt.Errorf("error reading schema version: %v", err)
+	}
+	fmt.Printf("Schema version %d, latest %d\n", current, latest)
+	if *status {
+		return nil
+	}
+
+	applied, err := s.Migrate()
+	for _, name := range applied {
+		fmt.Printf("Applied %s\n", name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Println("Nothing to do")
+	}
+	return nil
+}
+
+func backupCommand(args []string) error {
+	defaults := server.DefaultConfig()
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	dbPath := fs.String("db", defaults.Database, "SQLite database to back up")
+	dir := fs.String("dir", defaults.BackupDir, "directory to write the snapshot to")
+	keep := fs.Int("keep", defaults.BackupKeep, "number of snapshots to keep in -dir (0 keeps all)")
+	out := fs.String("out", "", "write the snapshot to this file instead of -dir, without rotation")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := checkDatabaseExists(*dbPath); err != nil {
+		return err
+	}
+	store, err := server.ConnectStore(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if *out != "" {
+		if err := store.Backup(*out); err != nil {
+			return fmt.Errorf("error backing up database: %v", err)
+		}
+		fmt.Printf("Wrote %s\n", *out)
+		return nil
+	}
+
+	snapshot, err := server.CreateSnapshot(store, *dir, *keep)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s (%d bytes)\n", filepath.Join(*dir, snapshot.Name), snapshot.Size)
+	return nil
+}
+
+func restoreCommand(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	dbPath := fs.String("db", server.DefaultConfig().Database, "SQLite database to replace")
+	force := fs.Bool("force", false, "replace the database if it already exists")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: postshortly restore [-db file] [-force] <snapshot>")
+	}
+
+	if _, err := os.Stat(*dbPath); err == nil && !*force {
+		return fmt.Errorf("%s exists; stop the server and pass -force to replace it", *dbPath)
+	}
+	if err := server.RestoreSnapshot(fs.Arg(0), *dbPath); err != nil {
+		return err
+	}
+	fmt.Printf("Restored %s from %s\n", *dbPath, fs.Arg(0))
+	return nil
+}
+
+// checkDatabaseExists keeps read-only commands from creating an empty SQLite
+// file when given a mistyped path.
+func checkDatabaseExists(database string) error {
+	if strings.Contains(database, "://") {
+		return nil
+	}
+	_, err := os.Stat(database)
+	return err
+}
+
+func signedUpdate(keyFile, body, link string, attachments ...string) (sdk.StatusUpdate, error) {
+	if body == "" {
+		return sdk.StatusUpdate{}, fmt.Errorf("body cannot be empty")
+	}
+
+	priv, err := loadPrivateKey(keyFile)
+	if err != nil {
+		return sdk.StatusUpdate{}, err
+	}
+
+	update := sdk.StatusUpdate{Body: body, Link: link, Attachments: attachments}
+	sdk.SignStatusUpdate(priv, &update)
+	return update, nil
+}
+
+func loadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key file: %v", err)
+	}
+	return sdk.ParsePrivateKey(string(data))
+}
+
+// shortKey abbreviates a pubkey for display. Servers and their peers may
+// send anything, so short keys are printed whole.
+func shortKey(pubkey string) string {
+	if len(pubkey) <= 8 {
+		return pubkey
+	}
+	return pubkey[:8] + "…"
+}
+
+// newClient returns a client for server that signs its requests with the
+// key in keyFile, if one is given.
+func newClient(server, keyFile string) (*sdk.Client, error) {
+	client := sdk.NewClient(server)
+	if keyFile != "" {
+		key, err := loadPrivateKey(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		client.Key = key
+	}
+	return client, nil
+}
+
+func fetchFeed(client *sdk.Client, pubkey string) ([]sdk.StatusUpdate, error) {
+	var updates []sdk.StatusUpdate
+	var err error
+	if pubkey != "" {
+		updates, err = client.FeedByPubkey(context.Background(), pubkey)
+	} else {
+		updates, err = client.Feed(context.Background())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching feed: %v", err)
+	}
+	return updates, nil
+}
+
+// readUpdates reads either a single post object or an array of posts.
+func readUpdates(path string) ([]sdk.StatusUpdate, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var updates []sdk.StatusUpdate
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		err = json.Unmarshal(data, &updates)
+	} else {
+		var update sdk.StatusUpdate
+		err = json.Unmarshal(data, &update)
+		updates = append(updates, update)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error decoding posts: %v", err)
+	}
+	return updates, nil
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func topCommand(args []string) error {
+	fs := flag.NewFlagSet("top", flag.ContinueOnError)
+	serverURL := fs.String("server", sdk.DefaultServer, "server address")
+	interval := fs.Duration("interval", 2*time.Second, "refresh interval")
+	once := fs.Bool("once", false, "print the statistics once and exit")
+	signKey := fs.String("sign", "", "private key file to sign requests with, for private instances")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	client, err := newClient(*serverURL, *signKey)
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		stats, err := client.Stats(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error getting statistics: %v", err)
+		}
+
+		if *once {
+			printStats(os.Stdout, *serverURL, stats)
+			return nil
+		}
+
+		// Clear the screen and move the cursor to the top-left corner.
+		fmt.Print("\033[2J\033[H")
+		printStats(os.Stdout, *serverURL, stats)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func printStats(w io.Writer, serverURL string, stats sdk.Statistics) {
+	formatTime := func(nanos int64) string {
+		if nanos == 0 {
+			return "-"
+		}
+		return time.Unix(0, nanos).Format("2006-01-02 03:04:05 PM")
+	}
+
+	fmt.Fprintf(w, "Live Statistics: %s\n", serverURL)
+	fmt.Fprintf(w, "-> Total Posts:           %d\n", stats.TotalPosts)
+	fmt.Fprintf(w, "-> Unique Pubkeys:        %d\n", stats.UniquePubkeys)
+	fmt.Fprintf(w, "-> Successful Requests:   %d\n", stats.SuccessfulRequests)
+	fmt.Fprintf(w, "-> Failed Requests:       %d\n", stats.FailedRequests)
+	fmt.Fprintf(w, "-> Total Requests:        %d\n", stats.TotalRequests)
+	fmt.Fprintf(w, "-> Avg. Per Pubkey:       %.2f\n", stats.AveragePostsPerPubkey)
+	fmt.Fprintf(w, "-> Most Recent Post Time: %s\n", formatTime(stats.MostRecentPostTimestamp))
+	fmt.Fprintf(w, "-> Oldest Post Time:      %s\n", formatTime(stats.OldestPostTimestamp))
+	fmt.Fprintf(w, "-> Limit (reqs/second):   %d\n", stats.RateLimitRequestsPerSecond)
+
+	if len(stats.TopProlificPubkeys) > 0 {
+		fmt.Fprintln(w, "\nTop Prolific Pubkeys:")
+		for i, pubkey := range stats.TopProlificPubkeys {
+			fmt.Fprintf(w, "%d. %s: %d posts\n", i+1, pubkey.Pubkey, pubkey.Count)
+		}
+	}
+}
+
+func dmCommand(args []string) error {
+	fs := flag.NewFlagSet("dm", flag.ContinueOnError)
+	serverURL := fs.String("server", sdk.DefaultServer, "server address")
+	keyFile := fs.String("key", defaultKeyFile, "private key file")
+	to := fs.String("to", "", "public key of the recipient")
+	body := fs.String("body", "", "message text")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *body == "" {
+		return fmt.Errorf("body cannot be empty")
+	}
+	recipient, err := hex.DecodeString(*to)
+	if err != nil || len(recipient) != ed25519.PublicKeySize {
+		return fmt.Errorf("-to must be a hex public key")
+	}
+
+	client, err := newClient(*serverURL, *keyFile)
+	if err != nil {
+		return err
+	}
+	if _, err := client.SendDirectMessage(context.Background(), recipient, *body); err != nil {
+		return fmt.Errorf("error sending message: %v", err)
+	}
+	return nil
+}
+
+func inboxCommand(args []string) error {
+	fs := flag.NewFlagSet("inbox", flag.ContinueOnError)
+	serverURL := fs.String("server", sdk.DefaultServer, "server address")
+	keyFile := fs.String("key", defaultKeyFile, "private key file")
+	del := fs.Bool("delete", false, "delete messages from the server once shown")
+	asJSON := fs.Bool("json", false, "print the decrypted messages as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	client, err := newClient(*serverURL, *keyFile)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	opened := []sdk.DirectMessage{}
+	for since := 0; ; {
+		page, err := client.Mailbox(ctx, since, server.MailboxPageSize)
+		if err != nil {
+			return fmt.Errorf("error fetching messages: %v", err)
+		}
+		for _, sealed := range page {
+			since = sealed.ID
+			msg, err := sdk.OpenDirectMessage(client.Key, sealed.Ciphertext)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "message %d: %v\n", sealed.ID, err)
+				continue
+			}
+			opened = append(opened, msg)
+			if *del {
+				if err := client.DeleteMessage(ctx, sealed.ID); err != nil {
+					return fmt.Errorf("error deleting message %d: %v", sealed.ID, err)
+				}
+			}
+		}
+		if len(page) < server.MailboxPageSize {
+			break
+		}
+	}
+
+	if *asJSON {
+		return printJSON(opened)
+	}
+	for _, msg := range opened {
+		fmt.Printf("%s  from %s  %s\n", time.Unix(msg.Sent, 0).Format("2006-01-02 03:04:05 PM"), shortKey(msg.From), msg.Body)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/donuts-are-good/postshortly/sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrintStats(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	printStats(&buf, "http://localhost:3495", sdk.Statistics{
+		TotalPosts:                 10,
+		UniquePubkeys:              4,
+		SuccessfulRequests:         15,
+		FailedRequests:             2,
+		TotalRequests:              17,
+		AveragePostsPerPubkey:      2.5,
+		RateLimitRequestsPerSecond: 10,
+		TopProlificPubkeys:         []sdk.ProlificPubkey{{Pubkey: "abc", Count: 7}, {Pubkey: "def", Count: 3}},
+	})
+	output := buf.String()
+
+	assert.Contains(t, output, "Live Statistics: http://localhost:3495\n")
+	assert.Contains(t, output, "-> Total Posts:           10\n")
+	assert.Contains(t, output, "-> Unique Pubkeys:        4\n")
+	assert.Contains(t, output, "-> Successful Requests:   15\n")
+	assert.Contains(t, output, "-> Failed Requests:       2\n")
+	assert.Contains(t, output, "-> Total Requests:        17\n")
+	assert.Contains(t, output, "-> Avg. Per Pubkey:       2.50\n")
+	assert.Contains(t, output, "-> Most Recent Post Time: -\n")
+	assert.Contains(t, output, "-> Oldest Post Time:      -\n")
+	assert.Contains(t, output, "-> Limit (reqs/second):   10\n")
+	assert.Contains(t, output, "Top Prolific Pubkeys:\n1. abc: 7 posts\n2. def: 3 posts\n")
+
+	// An instance without posts has no top list to print.
+	buf.Reset()
+	printStats(&buf, "http://localhost:3495", sdk.Statistics{})
+	assert.NotContains(t, buf.String(), "Top Prolific Pubkeys:")
+}
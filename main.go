@@ -2,74 +2,75 @@ package main
 
 import (
 	"context"
-	"crypto/ed25519"
+	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
-	"time"
 
-	"github.com/gorilla/handlers"
-	"golang.org/x/time/rate"
+	"github.com/donuts-are-good/postshortly/server"
 )
 
-const (
-	BodyMaxSize          = 256
-	LinkMaxSize          = 256
-	PubkeyMaxSize        = ed25519.PublicKeySize
-	SignatureMaxSize     = ed25519.SignatureSize
-	StatsRefreshInterval = 500 * time.Millisecond
-	Port                 = 3495
-)
+func main() {
+	args := os.Args[1:]
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
 
-type StatusUpdate struct {
-	ID        int    `json:"id"`
-	Timestamp int64  `json:"timestamp"`
-	Body      string `json:"body"`
-	Link      string `json:"link,omitempty"`
-	Pubkey    string `json:"pubkey"`
-	Signature string `json:"signature"`
+	if err := runCommand(args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
 }
 
-var (
-	limiter            = rate.NewLimiter(1, 1)
-	successfulRequests int
-	failedRequests     int
-)
-
-func main() {
-	if err := initDB(); err != nil {
-		fmt.Printf("Error initializing database: %v\n", err)
-		return
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to a JSON config file")
+	if err := fs.Parse(args); err != nil {
+		return err
 	}
-	defer db.Close()
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	go printLiveStats(ctx)
-	r := setupRouter()
+	config := server.DefaultConfig()
+	if *configPath != "" {
+		var err error
+		if config, err = server.LoadConfig(*configPath); err != nil {
+			return err
+		}
+	}
 
-	// Add CORS middleware
-	r.Use(corsMiddleware)
+	store, err := server.OpenStore(config.Database, config.AutoMigrate)
+	if err != nil {
+		return fmt.Errorf("error initializing database: %v", err)
+	}
+	defer store.Close()
 
-	loggedRouter := handlers.LoggingHandler(os.Stdout, r)
-	fmt.Printf("Started on port: %d\n", Port)
-	http.ListenAndServe(fmt.Sprintf(":%d", Port), loggedRouter)
-}
+	logOut, err := server.OpenLogOutput(config.Log.File)
+	if err != nil {
+		return fmt.Errorf("error opening log: %v", err)
+	}
+	accessOut, err := server.OpenLogOutput(config.Log.AccessLog)
+	if err != nil {
+		return fmt.Errorf("error opening access log: %v", err)
+	}
+	for _, out := range []io.WriteCloser{logOut, accessOut} {
+		if out != nil {
+			defer out.Close()
+		}
+	}
 
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Set CORS headers
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "*")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-		// Handle preflight requests
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+	srv, err := server.New(config, store)
+	if err != nil {
+		return err
+	}
+	srv.SetLogOutput(logOut, accessOut)
+	if err := srv.ReloadAccessLists(); err != nil {
+		return fmt.Errorf("error loading access lists: %v", err)
+	}
+	srv.Start(ctx)
 
-		// Call the next handler
-		next.ServeHTTP(w, r)
-	})
+	srv.Logger().Info("server started", "port", server.Port)
+	return http.ListenAndServe(fmt.Sprintf(":%d", server.Port), srv.Handler())
 }
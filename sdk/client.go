@@ -0,0 +1,226 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultServer is the address of a locally running instance.
+const DefaultServer = "http://localhost:3495"
+
+// DefaultTimeout is the Timeout of clients made by NewClient.
+const DefaultTimeout = 30 * time.Second
+
+// APIError is returned when the server answers with a non-2xx status. Code,
+// Field and RequestID are filled in from the server's JSON error body; Code
+// is the stable value to match on.
+type APIError struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	Field      string `json:"field,omitempty"`
+	RequestID  string `json:"request_id,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("server returned %d (%s): %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("server returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Client talks to a postshortly instance over HTTP.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	// Key, if set, signs every request, as private instances require.
+	Key ed25519.PrivateKey
+	// Timeout bounds each request and the reading of its response, except
+	// for Export, which streams for as long as the archive takes. Zero
+	// means no limit beyond the context.
+	Timeout time.Duration
+}
+
+// NewClient returns a Client for the instance at baseURL. The HTTP client
+// has no overall timeout, so streamed exports are not cut off; it only
+// bounds how long the server may take to start answering.
+func NewClient(baseURL string) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = DefaultTimeout
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: &http.Client{Transport: transport},
+		Timeout:    DefaultTimeout,
+	}
+}
+
+// Post publishes a signed status update and returns it as stored by the
+// server.
+func (c *Client) Post(ctx context.Context, update StatusUpdate) (StatusUpdate, error) {
+	body, err := json.Marshal(update)
+	if err != nil {
+		return StatusUpdate{}, err
+	}
+
+	var created StatusUpdate
+	err = c.do(ctx, http.MethodPost, "/status", bytes.NewReader(body), &created)
+	return created, err
+}
+
+// Feed returns every status update on the server, newest first.
+func (c *Client) Feed(ctx context.Context) ([]StatusUpdate, error) {
+	var updates []StatusUpdate
+	err := c.do(ctx, http.MethodGet, "/status", nil, &updates)
+	return updates, err
+}
+
+// FeedByPubkey returns the status updates posted by pubkey, newest first.
+func (c *Client) FeedByPubkey(ctx context.Context, pubkey string) ([]StatusUpdate, error) {
+	var updates []StatusUpdate
+	err := c.do(ctx, http.MethodGet, "/status/"+pubkey, nil, &updates)
+	return updates, err
+}
+
+// FeedSince returns up to limit posts with an id greater than since, oldest
+// first. Passing the id of the last post received pages through the feed.
+func (c *Client) FeedSince(ctx context.Context, since, limit int) ([]StatusUpdate, error) {
+	var updates []StatusUpdate
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/status?since=%d&limit=%d", since, limit), nil, &updates)
+	return updates, err
+}
+
+// Statistics mirrors the JSON served by GET /stats.
+type Statistics struct {
+	Timestamp                  int64            `json:"timestamp"`
+	TotalPosts                 int              `json:"total_posts"`
+	UniquePubkeys              int              `json:"unique_pubkeys"`
+	SuccessfulRequests         int              `json:"successful_requests"`
+	FailedRequests             int              `json:"failed_requests"`
+	TotalRequests              int              `json:"total_requests"`
+	AveragePostsPerPubkey      float64          `json:"average_posts_per_pubkey"`
+	MostRecentPostTimestamp    int64            `json:"most_recent_post_timestamp"`
+	OldestPostTimestamp        int64            `json:"oldest_post_timestamp"`
+	RateLimitRequestsPerSecond int              `json:"rate_limit_requests_per_second"`
+	TopProlificPubkeys         []ProlificPubkey `json:"top_prolific_pubkeys"`
+}
+
+// ProlificPubkey is a pubkey and how many posts it has made.
+type ProlificPubkey struct {
+	Pubkey string `json:"pubkey"`
+	Count  int    `json:"count"`
+}
+
+// Stats returns the statistics the server last recorded.
+func (c *Client) Stats(ctx context.Context) (Statistics, error) {
+	var stats Statistics
+	err := c.do(ctx, http.MethodGet, "/stats", nil, &stats)
+	return stats, err
+}
+
+// Media describes a file uploaded with UploadMedia.
+type Media struct {
+	Hash      string `json:"hash"`
+	MimeType  string `json:"mime_type"`
+	Size      int64  `json:"size"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Thumbnail bool   `json:"thumbnail"`
+	Pubkey    string `json:"pubkey"`
+	Created   int64  `json:"created"`
+}
+
+// UploadMedia stores data on the server so posts can attach it by hash.
+// The client's Key must be set, as uploads are signed. The server decides
+// the type from the bytes themselves.
+func (c *Client) UploadMedia(ctx context.Context, data []byte) (Media, error) {
+	var media Media
+	err := c.doContent(ctx, http.MethodPost, "/media", "application/octet-stream", bytes.NewReader(data), &media)
+	return media, err
+}
+
+// Export streams the server's archive of every post to w. See the archive
+// package for the format. Client.Timeout does not apply; pass a context
+// with a deadline to bound it.
+func (c *Client) Export(ctx context.Context, w io.Writer) error {
+	resp, err := c.send(ctx, http.MethodGet, "/export", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body io.Reader, out interface{}) error {
+	return c.doContent(ctx, method, path, "application/json", body, out)
+}
+
+// doContent performs a request within Client.Timeout and decodes the JSON
+// response into out, unless out is nil.
+func (c *Client) doContent(ctx context.Context, method, path, contentType string, body io.Reader, out interface{}) error {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	resp, err := c.sendContent(ctx, method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// send performs a request with a JSON body and turns non-2xx responses
+// into an *APIError.
+func (c *Client) send(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	return c.sendContent(ctx, method, path, "application/json", body)
+}
+
+// sendContent is send for bodies of any content type.
+func (c *Client) sendContent(ctx context.Context, method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.Key != nil {
+		if err := SignRequest(req, c.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		apiErr := &APIError{}
+		// Servers from before error codes, and proxies in front of the
+		// server, answer with plain text.
+		if err := json.Unmarshal(msg, apiErr); err != nil || apiErr.Code == "" {
+			apiErr = &APIError{Message: strings.TrimSpace(string(msg))}
+		}
+		apiErr.StatusCode = resp.StatusCode
+		return nil, apiErr
+	}
+
+	return resp, nil
+}
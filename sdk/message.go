@@ -0,0 +1,182 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+var ErrDecryptFailed = errors.New("message could not be decrypted")
+
+// fieldPrime is 2^255 - 19, the prime both curve25519 forms work over.
+var fieldPrime = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// X25519PublicKey converts an ed25519 public key to the X25519 key of the
+// same key pair: u = (1 + y) / (1 - y) on the Montgomery curve.
+func X25519PublicKey(pub ed25519.PublicKey) (*[32]byte, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, ErrInvalidPubkey
+	}
+
+	// The key is y in little endian with the sign of x in the top bit.
+	le := make([]byte, 32)
+	for i := range le {
+		le[i] = pub[31-i]
+	}
+	le[0] &= 0x7f
+	y := new(big.Int).SetBytes(le)
+
+	denominator := new(big.Int).Sub(big.NewInt(1), y)
+	denominator.Mod(denominator, fieldPrime)
+	if denominator.Sign() == 0 {
+		return nil, ErrInvalidPubkey
+	}
+	u := new(big.Int).Add(big.NewInt(1), y)
+	u.Mul(u, new(big.Int).ModInverse(denominator, fieldPrime))
+	u.Mod(u, fieldPrime)
+
+	var out [32]byte
+	u.FillBytes(out[:])
+	for i, j := 0, 31; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return &out, nil
+}
+
+// X25519PrivateKey converts an ed25519 private key to the X25519 key of the
+// same key pair, the clamped first half of the SHA-512 of the seed.
+func X25519PrivateKey(priv ed25519.PrivateKey) *[32]byte {
+	h := sha512.Sum512(priv.Seed())
+	var out [32]byte
+	copy(out[:], h[:32])
+	out[0] &= 248
+	out[31] &= 127
+	out[31] |= 64
+	return &out
+}
+
+// DirectMessage is what a sender seals for a recipient. The sender signs it
+// so the recipient knows who wrote it; the server only sees the sealed
+// bytes.
+type DirectMessage struct {
+	From      string `json:"from"`
+	Body      string `json:"body"`
+	Sent      int64  `json:"sent"`
+	Signature string `json:"signature"`
+}
+
+// directMessageSigned returns the bytes a direct message signature covers:
+// the hex recipient, the send time in Unix seconds and the body, joined by
+// newlines. Covering the recipient stops a message being passed on to
+// someone else as if it were written to them.
+func directMessageSigned(recipient string, sent int64, body string) []byte {
+	return []byte(recipient + "\n" + strconv.FormatInt(sent, 10) + "\n" + body)
+}
+
+// SealDirectMessage signs body with the sender's key and encrypts it to
+// recipient in an anonymous sealed box (libsodium's crypto_box_seal).
+func SealDirectMessage(priv ed25519.PrivateKey, recipient ed25519.PublicKey, body string) ([]byte, error) {
+	to, err := X25519PublicKey(recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	sent := time.Now().Unix()
+	msg := DirectMessage{
+		From:      hex.EncodeToString(priv.Public().(ed25519.PublicKey)),
+		Body:      body,
+		Sent:      sent,
+		Signature: hex.EncodeToString(ed25519.Sign(priv, directMessageSigned(hex.EncodeToString(recipient), sent, body))),
+	}
+	plaintext, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return box.SealAnonymous(nil, plaintext, to, rand.Reader)
+}
+
+// OpenDirectMessage decrypts a sealed message with the recipient's key and
+// checks the sender's signature.
+func OpenDirectMessage(priv ed25519.PrivateKey, sealed []byte) (DirectMessage, error) {
+	pub := priv.Public().(ed25519.PublicKey)
+	to, err := X25519PublicKey(pub)
+	if err != nil {
+		return DirectMessage{}, err
+	}
+
+	plaintext, ok := box.OpenAnonymous(nil, sealed, to, X25519PrivateKey(priv))
+	if !ok {
+		return DirectMessage{}, ErrDecryptFailed
+	}
+	var msg DirectMessage
+	if err := json.Unmarshal(plaintext, &msg); err != nil {
+		return DirectMessage{}, ErrDecryptFailed
+	}
+
+	from, err := hex.DecodeString(msg.From)
+	if err != nil || len(from) != ed25519.PublicKeySize {
+		return msg, ErrInvalidPubkey
+	}
+	signature, err := hex.DecodeString(msg.Signature)
+	if err != nil {
+		return msg, ErrInvalidSignature
+	}
+	if !ed25519.Verify(from, directMessageSigned(hex.EncodeToString(pub), msg.Sent, msg.Body), signature) {
+		return msg, ErrVerifyFailed
+	}
+	return msg, nil
+}
+
+// SealedMessage is a direct message as stored in a mailbox.
+type SealedMessage struct {
+	ID        int    `json:"id"`
+	Recipient string `json:"recipient"`
+	// Ciphertext is the sealed box, base64 encoded in JSON.
+	Ciphertext []byte `json:"ciphertext"`
+	Created    int64  `json:"created"`
+}
+
+// SendDirectMessage seals body for recipient and leaves it in their mailbox
+// on the server. The client's Key signs both the message and the request.
+func (c *Client) SendDirectMessage(ctx context.Context, recipient ed25519.PublicKey, body string) (SealedMessage, error) {
+	if c.Key == nil {
+		return SealedMessage{}, errors.New("sending a direct message needs the client's Key")
+	}
+	sealed, err := SealDirectMessage(c.Key, recipient, body)
+	if err != nil {
+		return SealedMessage{}, err
+	}
+	payload, err := json.Marshal(SealedMessage{Ciphertext: sealed})
+	if err != nil {
+		return SealedMessage{}, err
+	}
+
+	var stored SealedMessage
+	err = c.do(ctx, http.MethodPost, "/messages/"+hex.EncodeToString(recipient), bytes.NewReader(payload), &stored)
+	return stored, err
+}
+
+// Mailbox returns up to limit sealed messages for the client's Key with an
+// id greater than since, oldest first.
+func (c *Client) Mailbox(ctx context.Context, since, limit int) ([]SealedMessage, error) {
+	var messages []SealedMessage
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/messages?since=%d&limit=%d", since, limit), nil, &messages)
+	return messages, err
+}
+
+// DeleteMessage removes a message from the client's mailbox.
+func (c *Client) DeleteMessage(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/messages/%d", id), nil, nil)
+}
@@ -0,0 +1,66 @@
+package sdk
+
+import (
+	"context"
+	"crypto/sha256"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// ProofOfWorkMaxBits is the highest difficulty an instance may ask for.
+const ProofOfWorkMaxBits = 32
+
+// ProofOfWorkMessage returns the bytes hashed to check a post's proof of
+// work: the hex pubkey, the hex signature and the nonce, joined by
+// newlines. Covering the signature ties the work to one post.
+func ProofOfWorkMessage(pubkey, signature, nonce string) []byte {
+	return []byte(pubkey + "\n" + signature + "\n" + nonce)
+}
+
+// ProofOfWorkBits returns the number of leading zero bits in the SHA-256
+// of the post's proof of work message.
+func ProofOfWorkBits(update StatusUpdate) int {
+	sum := sha256.Sum256(ProofOfWorkMessage(update.Pubkey, update.Signature, update.Nonce))
+	n := 0
+	for _, b := range sum {
+		n += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return n
+}
+
+// SolveProofOfWork sets the nonce of a signed update so that it carries at
+// least difficulty bits of work. Each extra bit doubles the expected time.
+func SolveProofOfWork(ctx context.Context, update *StatusUpdate, difficulty int) error {
+	update.Nonce = ""
+	if difficulty <= 0 {
+		return nil
+	}
+	for n := uint64(0); ; n++ {
+		if n%(1<<16) == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		update.Nonce = strconv.FormatUint(n, 36)
+		if ProofOfWorkBits(*update) >= difficulty {
+			return nil
+		}
+	}
+}
+
+// ProofOfWork is the JSON served by GET /pow.
+type ProofOfWork struct {
+	Pubkey     string `json:"pubkey,omitempty"`
+	Difficulty int    `json:"difficulty"`
+}
+
+// ProofOfWorkDifficulty asks the server how many bits of work a post by
+// pubkey needs.
+func (c *Client) ProofOfWorkDifficulty(ctx context.Context, pubkey string) (int, error) {
+	var pow ProofOfWork
+	err := c.do(ctx, http.MethodGet, "/pow?pubkey="+url.QueryEscape(pubkey), nil, &pow)
+	return pow.Difficulty, err
+}
@@ -0,0 +1,69 @@
+package sdk
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers carrying the signature of a signed request. Any request may be
+// signed; instances use the key to let operators into the admin API and,
+// in private mode, allowed keys into the read endpoints.
+const (
+	RequestKeyHeader       = "X-Postshortly-Key"
+	RequestTimestampHeader = "X-Postshortly-Timestamp"
+	RequestNonceHeader     = "X-Postshortly-Nonce"
+	RequestSignatureHeader = "X-Postshortly-Request-Signature"
+)
+
+// RequestNonceMaxSize bounds the nonce of a signed request.
+const RequestNonceMaxSize = 64
+
+// RequestMessage returns the bytes signed for a request: the method, the
+// host, the request URI, the Unix timestamp, the nonce and the hex SHA-256
+// of the body, separated by newlines. The host keeps a request signed for
+// one instance from being replayed against another.
+func RequestMessage(method, host, requestURI string, timestamp int64, nonce string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	return []byte(method + "\n" + strings.ToLower(host) + "\n" + requestURI + "\n" + strconv.FormatInt(timestamp, 10) + "\n" + nonce + "\n" + hex.EncodeToString(sum[:]))
+}
+
+// SignRequest adds the signed request headers to req with a fresh random
+// nonce, so the request can only be sent once. The body, if any, is read
+// and replaced.
+func SignRequest(req *http.Request, priv ed25519.PrivateKey) error {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(raw[:])
+
+	timestamp := time.Now().Unix()
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	msg := RequestMessage(req.Method, host, req.URL.RequestURI(), timestamp, nonce, body)
+	req.Header.Set(RequestKeyHeader, hex.EncodeToString(priv.Public().(ed25519.PublicKey)))
+	req.Header.Set(RequestTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(RequestNonceHeader, nonce)
+	req.Header.Set(RequestSignatureHeader, hex.EncodeToString(ed25519.Sign(priv, msg)))
+	return nil
+}
@@ -0,0 +1,205 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+func TestMessageOrder(t *testing.T) {
+	pub, _, _ := GenerateKey()
+
+	msg := Message(pub, "body", "link")
+	assert.Equal(t, append(append([]byte(pub), "body"...), "link"...), msg)
+
+	// Message must not write into the caller's key.
+	assert.Len(t, pub, ed25519.PublicKeySize)
+
+	// Attachments follow a NUL byte, so no body and link can sign the same
+	// bytes as a post with them.
+	msg = Message(pub, "body", "link", "aa", "bb")
+	assert.Equal(t, append(append([]byte(pub), "bodylink"...), "\x00aa,bb"...), msg)
+}
+
+func TestSignAndVerify(t *testing.T) {
+	_, priv, _ := GenerateKey()
+
+	update := StatusUpdate{Body: "Test body", Link: "http://example.com"}
+	SignStatusUpdate(priv, &update)
+	assert.NoError(t, VerifyStatusUpdate(update))
+
+	update.Link = "http://example.org"
+	assert.ErrorIs(t, VerifyStatusUpdate(update), ErrVerifyFailed)
+
+	update = StatusUpdate{Body: "Test body", Attachments: []string{"aa", "bb"}}
+	SignStatusUpdate(priv, &update)
+	assert.NoError(t, VerifyStatusUpdate(update))
+	update.Attachments = update.Attachments[:1]
+	assert.ErrorIs(t, VerifyStatusUpdate(update), ErrVerifyFailed)
+
+	update.Pubkey = "zz"
+	assert.ErrorIs(t, VerifyStatusUpdate(update), ErrInvalidPubkey)
+}
+
+func TestSolveProofOfWork(t *testing.T) {
+	_, priv, _ := GenerateKey()
+	update := StatusUpdate{Body: "Test body"}
+	SignStatusUpdate(priv, &update)
+
+	assert.NoError(t, SolveProofOfWork(context.Background(), &update, 12))
+	assert.NotEmpty(t, update.Nonce)
+	assert.GreaterOrEqual(t, ProofOfWorkBits(update), 12)
+
+	assert.Equal(t, []byte(update.Pubkey+"\n"+update.Signature+"\n"+update.Nonce), ProofOfWorkMessage(update.Pubkey, update.Signature, update.Nonce))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, SolveProofOfWork(ctx, &update, ProofOfWorkMaxBits), context.Canceled)
+}
+
+func TestX25519Keys(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		pub, priv, _ := GenerateKey()
+		derived, err := curve25519.X25519(X25519PrivateKey(priv)[:], curve25519.Basepoint)
+		assert.NoError(t, err)
+		converted, err := X25519PublicKey(pub)
+		assert.NoError(t, err)
+		assert.Equal(t, derived, converted[:])
+	}
+}
+
+func TestDirectMessages(t *testing.T) {
+	alice, alicePriv, _ := GenerateKey()
+	bob, bobPriv, _ := GenerateKey()
+	_, evePriv, _ := GenerateKey()
+
+	sealed, err := SealDirectMessage(alicePriv, bob, "hi bob")
+	assert.NoError(t, err)
+
+	msg, err := OpenDirectMessage(bobPriv, sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, "hi bob", msg.Body)
+	assert.Equal(t, hex.EncodeToString(alice), msg.From)
+
+	_, err = OpenDirectMessage(evePriv, sealed)
+	assert.ErrorIs(t, err, ErrDecryptFailed)
+
+	sealed[len(sealed)-1] ^= 1
+	_, err = OpenDirectMessage(bobPriv, sealed)
+	assert.ErrorIs(t, err, ErrDecryptFailed)
+
+	// A message Alice wrote to Eve can't be passed on to Bob as his own.
+	toEve, err := SealDirectMessage(alicePriv, evePriv.Public().(ed25519.PublicKey), "hi eve")
+	assert.NoError(t, err)
+	forwarded, _ := OpenDirectMessage(evePriv, toEve)
+	plaintext, _ := json.Marshal(forwarded)
+	bobX, _ := X25519PublicKey(bob)
+	resealed, _ := box.SealAnonymous(nil, plaintext, bobX, nil)
+	_, err = OpenDirectMessage(bobPriv, resealed)
+	assert.ErrorIs(t, err, ErrVerifyFailed)
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	_, priv, _ := GenerateKey()
+
+	parsed, err := ParsePrivateKey(EncodePrivateKey(priv) + "\n")
+	assert.NoError(t, err)
+	assert.Equal(t, priv, parsed)
+
+	parsed, err = ParsePrivateKey(hex.EncodeToString(priv.Seed()))
+	assert.NoError(t, err)
+	assert.Equal(t, priv, parsed)
+
+	_, err = ParsePrivateKey("abcd")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
+
+func TestClientPost(t *testing.T) {
+	_, priv, _ := GenerateKey()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var update StatusUpdate
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&update))
+		assert.NoError(t, VerifyStatusUpdate(update))
+		update.ID = 7
+		json.NewEncoder(w).Encode(update)
+	}))
+	defer srv.Close()
+
+	update := StatusUpdate{Body: "Test body"}
+	SignStatusUpdate(priv, &update)
+
+	created, err := NewClient(srv.URL).Post(context.Background(), update)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, created.ID)
+}
+
+func TestClientAPIError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	_, err := NewClient(srv.URL).Feed(context.Background())
+	var apiErr *APIError
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusTooManyRequests, apiErr.StatusCode)
+	assert.Equal(t, "Rate limit exceeded", apiErr.Message)
+}
+
+func TestClientAPIErrorEnvelope(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"code":"body_too_long","message":"body exceeds maximum size of 256 characters","field":"body","request_id":"abc"}`))
+	}))
+	defer srv.Close()
+
+	_, err := NewClient(srv.URL).Post(context.Background(), StatusUpdate{Body: "x"})
+	var apiErr *APIError
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, &APIError{
+		StatusCode: http.StatusBadRequest,
+		Code:       "body_too_long",
+		Message:    "body exceeds maximum size of 256 characters",
+		Field:      "body",
+		RequestID:  "abc",
+	}, apiErr)
+}
+
+func TestClientTimeoutSparesExport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Stream for longer than the client timeout.
+		line := "line\n"
+		if r.URL.Path == "/status" {
+			line = "["
+		}
+		for i := 0; i < 5; i++ {
+			w.Write([]byte(line))
+			w.(http.Flusher).Flush()
+			time.Sleep(20 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	client := NewClient(srv.URL)
+	client.Timeout = 30 * time.Millisecond
+
+	var out bytes.Buffer
+	assert.NoError(t, client.Export(context.Background(), &out))
+	assert.Equal(t, strings.Repeat("line\n", 5), out.String())
+
+	_, err := client.Feed(context.Background())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,126 @@
+// Package sdk implements the postshortly signing scheme and a small client
+// for the HTTP API, so programs don't have to rebuild the signed message by
+// hand.
+package sdk
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrInvalidPubkey    = errors.New("invalid pubkey")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrInvalidKey       = errors.New("invalid private key")
+	ErrVerifyFailed     = errors.New("signature verification failed")
+)
+
+// StatusUpdate mirrors the JSON representation of a post served by the API.
+type StatusUpdate struct {
+	ID        int    `json:"id,omitempty"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+	Body      string `json:"body"`
+	Link      string `json:"link,omitempty"`
+	Pubkey    string `json:"pubkey"`
+	Signature string `json:"signature"`
+	// Origin is the peer instance a federated post was fetched from. It is
+	// set by the receiving instance and not covered by the signature.
+	Origin string `json:"origin,omitempty"`
+	// Attachments are the SHA-256 hashes of media uploaded with
+	// Client.UploadMedia. They are covered by the signature.
+	Attachments []string `json:"attachments,omitempty"`
+	// Nonce carries the proof of work some instances require before
+	// accepting a post. It is not stored. See SolveProofOfWork.
+	Nonce string `json:"nonce,omitempty"`
+}
+
+// GenerateKey returns a new ed25519 key pair.
+func GenerateKey() (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	return ed25519.GenerateKey(rand.Reader)
+}
+
+// EncodePrivateKey returns the hex encoding of a private key, the format
+// read back by ParsePrivateKey.
+func EncodePrivateKey(priv ed25519.PrivateKey) string {
+	return hex.EncodeToString(priv)
+}
+
+// ParsePrivateKey decodes a hex encoded private key. Both the 32 byte seed
+// and the 64 byte expanded form are accepted; surrounding whitespace is
+// ignored so key files can end in a newline.
+func ParsePrivateKey(s string) (ed25519.PrivateKey, error) {
+	raw, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	switch len(raw) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(raw), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.NewKeyFromSeed(raw[:ed25519.SeedSize]), nil
+	default:
+		return nil, ErrInvalidKey
+	}
+}
+
+// Message returns the bytes a post signature covers: the raw public key
+// followed by the body and then the link. Posts with attachments add a NUL
+// byte and the hex hashes joined by commas, so posts without them sign the
+// same bytes as before attachments existed.
+func Message(pubkey ed25519.PublicKey, body, link string, attachments ...string) []byte {
+	msg := make([]byte, 0, len(pubkey)+len(body)+len(link))
+	msg = append(msg, pubkey...)
+	msg = append(msg, body...)
+	msg = append(msg, link...)
+	if len(attachments) > 0 {
+		msg = append(msg, 0)
+		msg = append(msg, strings.Join(attachments, ",")...)
+	}
+	return msg
+}
+
+// Sign signs body, link and any attachment hashes with priv and returns the
+// hex encoded public key and signature, ready to be sent as the pubkey and
+// signature fields.
+func Sign(priv ed25519.PrivateKey, body, link string, attachments ...string) (pubkey, signature string) {
+	pub := priv.Public().(ed25519.PublicKey)
+	sig := ed25519.Sign(priv, Message(pub, body, link, attachments...))
+	return hex.EncodeToString(pub), hex.EncodeToString(sig)
+}
+
+// SignStatusUpdate fills in the Pubkey and Signature fields of update.
+func SignStatusUpdate(priv ed25519.PrivateKey, update *StatusUpdate) {
+	update.Pubkey, update.Signature = Sign(priv, update.Body, update.Link, update.Attachments...)
+}
+
+// Verify reports whether signature is a valid signature of body, link and
+// any attachment hashes by pubkey.
+func Verify(pubkey ed25519.PublicKey, signature []byte, body, link string, attachments ...string) bool {
+	if len(pubkey) != ed25519.PublicKeySize || len(signature) != ed25519.SignatureSize {
+		return false
+	}
+	return ed25519.Verify(pubkey, Message(pubkey, body, link, attachments...), signature)
+}
+
+// VerifyStatusUpdate decodes the hex fields of update and checks its
+// signature.
+func VerifyStatusUpdate(update StatusUpdate) error {
+	pubkey, err := hex.DecodeString(update.Pubkey)
+	if err != nil || len(pubkey) != ed25519.PublicKeySize {
+		return ErrInvalidPubkey
+	}
+
+	signature, err := hex.DecodeString(update.Signature)
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return ErrInvalidSignature
+	}
+
+	if !Verify(pubkey, signature, update.Body, update.Link, update.Attachments...) {
+		return ErrVerifyFailed
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Access modes.
+const (
+	// AccessOpen lets every pubkey that isn't banned or denied post.
+	AccessOpen = "open"
+	// AccessAllowlist only lets allowed pubkeys post.
+	AccessAllowlist = "allowlist"
+	// AccessPrivate only lets allowed pubkeys post, and only lets signed
+	// requests from them read.
+	AccessPrivate = "private"
+)
+
+// AccessConfig restricts which pubkeys may use an instance.
+type AccessConfig struct {
+	// Mode is "open", "allowlist" or "private".
+	Mode string `json:"mode"`
+	// AllowFile names a file of pubkeys allowed in addition to those added
+	// through the admin API.
+	AllowFile string `json:"allow_file"`
+	// DenyFile names a file of pubkeys whose posts are refused in every
+	// mode.
+	DenyFile string `json:"deny_file"`
+	// ReloadInterval is how often the files are checked for changes.
+	ReloadInterval Duration `json:"reload_interval"`
+}
+
+// keyFile is a set of pubkeys read from a file with one hex key per line.
+// Blank lines and lines starting with # are skipped.
+type keyFile struct {
+	path string
+
+	mu      sync.RWMutex
+	modTime time.Time
+	size    int64
+	keys    map[string]bool
+}
+
+// reload reads the file again if it changed since the last read. The old
+// keys are kept when it can't be read.
+func (f *keyFile) reload() (changed bool, err error) {
+	if f.path == "" {
+		return false, nil
+	}
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return false, fmt.Errorf("error reading %s: %v", f.path, err)
+	}
+
+	f.mu.RLock()
+	unchanged := f.keys != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size
+	f.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return false, fmt.Errorf("error reading %s: %v", f.path, err)
+	}
+	keys, err := parseKeyFile(data)
+	if err != nil {
+		return false, fmt.Errorf("error reading %s: %v", f.path, err)
+	}
+
+	f.mu.Lock()
+	f.keys, f.modTime, f.size = keys, info.ModTime(), info.Size()
+	f.mu.Unlock()
+	return true, nil
+}
+
+func (f *keyFile) contains(pubkey string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.keys[strings.ToLower(pubkey)]
+}
+
+func (f *keyFile) len() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.keys)
+}
+
+func parseKeyFile(data []byte) (map[string]bool, error) {
+	keys := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if raw, err := hex.DecodeString(line); err != nil || len(raw) != PubkeyMaxSize {
+			return nil, fmt.Errorf("line %d: bad pubkey %q", n, line)
+		}
+		keys[strings.ToLower(line)] = true
+	}
+	return keys, scanner.Err()
+}
+
+// ReloadAccessLists reads the allow and deny files of the config if they
+// changed. Start rereads them periodically; calling it before Start
+// catches mistakes in the files early.
+func (s *Server) ReloadAccessLists() error {
+	for _, f := range []*keyFile{&s.allowFile, &s.denyFile} {
+		changed, err := f.reload()
+		if err != nil {
+			return err
+		}
+		if changed {
+			s.log.Info("access list loaded", "file", f.path, "keys", f.len())
+		}
+	}
+	return nil
+}
+
+func (s *Server) runAccessListReload(ctx context.Context) {
+	if s.allowFile.path == "" && s.denyFile.path == "" {
+		return
+	}
+	if err := s.ReloadAccessLists(); err != nil {
+		s.log.Error("error loading access lists", "error", err)
+	}
+
+	ticker := time.NewTicker(time.Duration(s.config.Access.ReloadInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.ReloadAccessLists(); err != nil {
+				s.log.Error("error loading access lists", "error", err)
+			}
+		}
+	}
+}
+
+// checkPubkeyAccess returns a *ValidationError if pubkey may not use the
+// instance: it is in the deny file or banned, or it isn't allowed while
+// the instance is restricted.
+func (s *Server) checkPubkeyAccess(pubkey string) error {
+	pubkey = strings.ToLower(pubkey)
+	if s.denyFile.contains(pubkey) {
+		return &ValidationError{Code: CodePubkeyDenied, Field: "pubkey", Message: "pubkey is denied"}
+	}
+
+	banned, err := s.store.IsPubkeyBanned(pubkey)
+	if err != nil {
+		return fmt.Errorf("error checking pubkey: %v", err)
+	}
+	if banned {
+		return &ValidationError{Code: CodePubkeyBanned, Field: "pubkey", Message: "pubkey is banned"}
+	}
+
+	if s.config.Access.Mode == AccessOpen || s.config.Access.Mode == "" {
+		return nil
+	}
+	if s.allowFile.contains(pubkey) || s.isOperatorKey(pubkey) {
+		return nil
+	}
+	allowed, err := s.store.IsPubkeyAllowed(pubkey)
+	if err != nil {
+		return fmt.Errorf("error checking pubkey: %v", err)
+	}
+	if !allowed {
+		return &ValidationError{Code: CodePubkeyNotAllowed, Field: "pubkey", Message: "pubkey is not allowed on this instance"}
+	}
+	return nil
+}
+
+// requireReader guards the read endpoints of a private instance: only
+// requests signed by an allowed pubkey get through. Other modes are left
+// open.
+func (s *Server) requireReader(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.config.Access.Mode != AccessPrivate {
+			next(w, r)
+			return
+		}
+
+		s.requireSignature(func(w http.ResponseWriter, r *http.Request) {
+			if err := s.checkPubkeyAccess(signer(r)); err != nil {
+				s.handleAccessError(w, r, err)
+				return
+			}
+			next(w, r)
+		})(w, r)
+	}
+}
+
+func (s *Server) handleAccessError(w http.ResponseWriter, r *http.Request, err error) {
+	if verr, ok := err.(*ValidationError); ok {
+		s.writeError(w, r, ErrorResponse{Code: verr.Code, Message: verr.Message, Field: verr.Field}, http.StatusForbidden)
+		return
+	}
+	s.log.ErrorContext(r.Context(), "error checking access", "error", err)
+	s.handleError(w, r, CodeInternal, "Error checking pubkey", http.StatusInternalServerError)
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/donuts-are-good/postshortly/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestParseKeyFile(t *testing.T) {
+	t.Parallel()
+	a := strings.Repeat("ab", PubkeyMaxSize)
+	b := strings.Repeat("CD", PubkeyMaxSize)
+
+	keys, err := parseKeyFile([]byte("# members\n" + a + "\n\n  " + b + "  \n"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{a: true, strings.ToLower(b): true}, keys)
+
+	_, err = parseKeyFile([]byte(a + "\nnot-a-key\n"))
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestAccessListReload(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "deny")
+	srv.denyFile.path = path
+	a := strings.Repeat("ab", PubkeyMaxSize)
+	b := strings.Repeat("cd", PubkeyMaxSize)
+
+	assert.Error(t, srv.ReloadAccessLists(), "a missing file is an error")
+
+	require.NoError(t, os.WriteFile(path, []byte(a+"\n"), 0o644))
+	require.NoError(t, srv.ReloadAccessLists())
+	assert.True(t, srv.denyFile.contains(a))
+	assert.False(t, srv.denyFile.contains(b))
+
+	require.NoError(t, os.WriteFile(path, []byte(a+"\n"+b+"\n"), 0o644))
+	require.NoError(t, srv.ReloadAccessLists())
+	assert.True(t, srv.denyFile.contains(b))
+
+	// A broken file keeps the last good list.
+	require.NoError(t, os.WriteFile(path, []byte("oops\n"), 0o644))
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, future, future))
+	assert.Error(t, srv.ReloadAccessLists())
+	assert.True(t, srv.denyFile.contains(a))
+	assert.True(t, srv.denyFile.contains(b))
+}
+
+func TestAccessDenyFile(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	srv.limiter = rate.NewLimiter(rate.Inf, 1)
+	pub, priv, _ := sdk.GenerateKey()
+	path := filepath.Join(t.TempDir(), "deny")
+	require.NoError(t, os.WriteFile(path, []byte(hex.EncodeToString(pub)+"\n"), 0o644))
+	srv.denyFile.path = path
+	require.NoError(t, srv.ReloadAccessLists())
+	handler := srv.Handler()
+
+	post := sdk.StatusUpdate{Body: "hello"}
+	sdk.SignStatusUpdate(priv, &post)
+	rr := postStatusUpdate(t, handler, post)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	var resp ErrorResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.Equal(t, CodePubkeyDenied, resp.Code)
+
+	rejections, err := srv.store.GetRejections(10)
+	require.NoError(t, err)
+	require.Len(t, rejections, 1)
+	assert.Equal(t, CodePubkeyDenied, rejections[0].Code)
+}
+
+func TestAccessAllowlist(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	srv.limiter = rate.NewLimiter(rate.Inf, 1)
+	srv.config.AdminToken = testAdminToken
+	srv.config.Access.Mode = AccessAllowlist
+	handler := srv.Handler()
+
+	pub, priv, _ := sdk.GenerateKey()
+	post := sdk.StatusUpdate{Body: "hello"}
+	sdk.SignStatusUpdate(priv, &post)
+
+	rr := postStatusUpdate(t, handler, post)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	var resp ErrorResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.Equal(t, CodePubkeyNotAllowed, resp.Code)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, adminRequest("PUT", "/admin/allow/"+hex.EncodeToString(pub), strings.NewReader(`{"reason":"member"}`)))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = postStatusUpdate(t, handler, post)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, adminRequest("GET", "/admin/allow", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var allowed []AllowedPubkey
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&allowed))
+	require.Len(t, allowed, 1)
+	assert.Equal(t, "member", allowed[0].Reason)
+
+	// Reads stay open outside private mode.
+	assert.Equal(t, 1, feedLength(t, handler))
+
+	// Keys are matched whatever the case of their hex, both when they are
+	// allowed and when they post.
+	_, other, _ := sdk.GenerateKey()
+	upper := sdk.StatusUpdate{Body: "shouting"}
+	sdk.SignStatusUpdate(other, &upper)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, adminRequest("PUT", "/admin/allow/"+strings.ToUpper(upper.Pubkey), nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	rr = postStatusUpdate(t, handler, upper)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	again := sdk.StatusUpdate{Body: "hello again"}
+	sdk.SignStatusUpdate(priv, &again)
+	again.Pubkey = strings.ToUpper(again.Pubkey)
+	rr = postStatusUpdate(t, handler, again)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, adminRequest("DELETE", "/admin/allow/"+strings.ToUpper(upper.Pubkey), nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestAccessPrivate(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	srv.config.Access.Mode = AccessPrivate
+	pub, priv, _ := sdk.GenerateKey()
+	path := filepath.Join(t.TempDir(), "allow")
+	require.NoError(t, os.WriteFile(path, []byte(hex.EncodeToString(pub)+"\n"), 0o644))
+	srv.allowFile.path = path
+	require.NoError(t, srv.ReloadAccessLists())
+	handler := srv.Handler()
+	_, stranger, _ := sdk.GenerateKey()
+
+	signed := func(target string, key []byte) *http.Request {
+		req := httptest.NewRequest("GET", target, nil)
+		require.NoError(t, sdk.SignRequest(req, key))
+		return req
+	}
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		code int
+	}{
+		{"unsigned feed", httptest.NewRequest("GET", "/status", nil), http.StatusUnauthorized},
+		{"unsigned stats", httptest.NewRequest("GET", "/stats", nil), http.StatusUnauthorized},
+		{"stranger", signed("/status", stranger), http.StatusForbidden},
+		{"member feed", signed("/status", priv), http.StatusOK},
+		{"member pubkey feed", signed("/status/"+hex.EncodeToString(pub), priv), http.StatusOK},
+		{"member export", signed("/export", priv), http.StatusOK},
+		{"health", httptest.NewRequest("GET", "/healthz", nil), http.StatusOK},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, tt.req)
+		assert.Equal(t, tt.code, rr.Code, "%s: %s", tt.name, rr.Body.String())
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+type adminActorKey struct{}
+
+// requireAdmin only lets requests through that carry the configured admin
+// token as "Authorization: Bearer <token>" or are signed by one of the
+// operator keys. Without either configured the admin endpoints are
+// disabled.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.config.AdminToken == "" && len(s.config.OperatorKeys) == 0 {
+			s.handleError(w, r, CodeAdminDisabled, "Admin API is disabled", http.StatusForbidden)
+			return
+		}
+
+		var actor string
+		if pubkey := signer(r); pubkey != "" {
+			if !s.isOperatorKey(pubkey) {
+				s.handleError(w, r, CodeUnauthorized, "Unauthorized: not an operator key", http.StatusUnauthorized)
+				return
+			}
+			if !s.recordSigner(w, r) {
+				return
+			}
+			actor = "operator:" + pubkey
+		} else {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if s.config.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				s.handleError(w, r, CodeUnauthorized, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			actor = "token"
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), adminActorKey{}, actor)))
+	}
+}
+
+func (s *Server) isOperatorKey(pubkey string) bool {
+	for _, key := range s.config.OperatorKeys {
+		if strings.EqualFold(key, pubkey) {
+			return true
+		}
+	}
+	return false
+}
+
+// adminActor names who made an admin request: "token" or
+// "operator:<pubkey>".
+func adminActor(r *http.Request) string {
+	actor, _ := r.Context().Value(adminActorKey{}).(string)
+	return actor
+}
@@ -0,0 +1,398 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/donuts-are-good/postshortly/sdk"
+	"github.com/gorilla/mux"
+	"golang.org/x/time/rate"
+)
+
+func (s *Server) setupRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/status", s.createStatusUpdate).Methods("POST")
+	r.HandleFunc("/status/{pubkey}", s.requireReader(s.getStatusUpdatesByPubkey)).Methods("GET")
+	r.HandleFunc("/status", s.requireReader(s.getAllStatusUpdates)).Methods("GET")
+	r.HandleFunc("/pow", s.getProofOfWorkHandler).Methods("GET")
+	r.HandleFunc("/stats", s.requireReader(s.getStatisticsHandler)).Methods("GET")
+	r.HandleFunc("/dashboard", s.dashboardHandler).Methods("GET")
+	r.HandleFunc("/healthz", s.healthzHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/readyz", s.readyzHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/version", s.versionHandler).Methods("GET")
+	r.HandleFunc("/export", s.requireReader(s.exportHandler)).Methods("GET")
+	r.HandleFunc("/media", s.requireSignature(s.uploadMediaHandler)).Methods("POST").Name(uploadMediaRoute)
+	r.HandleFunc("/media/{hash:[0-9a-f]{64}}", s.requireReader(s.getMediaHandler)).Methods("GET", "HEAD")
+	r.HandleFunc("/media/{hash:[0-9a-f]{64}}/thumbnail", s.requireReader(s.getThumbnailHandler)).Methods("GET", "HEAD")
+	r.HandleFunc("/messages", s.requireSignature(s.getMessagesHandler)).Methods("GET")
+	r.HandleFunc("/messages/{pubkey}", s.requireSignature(s.sendMessageHandler)).Methods("POST")
+	r.HandleFunc("/messages/{id:[0-9]+}", s.requireSignature(s.deleteMessageHandler)).Methods("DELETE")
+	r.HandleFunc("/webhooks", s.requireAdmin(s.createWebhookHandler)).Methods("POST")
+	r.HandleFunc("/webhooks", s.requireAdmin(s.getWebhooksHandler)).Methods("GET")
+	r.HandleFunc("/webhooks/{id}", s.requireAdmin(s.deleteWebhookHandler)).Methods("DELETE")
+	r.HandleFunc("/webhooks/{id}/deliveries", s.requireAdmin(s.getWebhookDeliveriesHandler)).Methods("GET")
+	r.HandleFunc("/backups", s.requireAdmin(s.createBackupHandler)).Methods("POST")
+	r.HandleFunc("/backups", s.requireAdmin(s.getBackupsHandler)).Methods("GET")
+	r.HandleFunc("/backups/{name}", s.requireAdmin(s.downloadBackupHandler)).Methods("GET")
+	r.HandleFunc("/admin/status/{id}/hide", s.requireAdmin(s.hideStatusUpdateHandler)).Methods("POST")
+	r.HandleFunc("/admin/status/{id}/hide", s.requireAdmin(s.unhideStatusUpdateHandler)).Methods("DELETE")
+	r.HandleFunc("/admin/bans", s.requireAdmin(s.getBansHandler)).Methods("GET")
+	r.HandleFunc("/admin/bans/{pubkey}", s.requireAdmin(s.banHandler)).Methods("PUT")
+	r.HandleFunc("/admin/bans/{pubkey}", s.requireAdmin(s.unbanHandler)).Methods("DELETE")
+	r.HandleFunc("/admin/allow", s.requireAdmin(s.getAllowedPubkeysHandler)).Methods("GET")
+	r.HandleFunc("/admin/allow/{pubkey}", s.requireAdmin(s.allowHandler)).Methods("PUT")
+	r.HandleFunc("/admin/allow/{pubkey}", s.requireAdmin(s.disallowHandler)).Methods("DELETE")
+	r.HandleFunc("/admin/rejections", s.requireAdmin(s.getRejectionsHandler)).Methods("GET")
+	r.HandleFunc("/admin/log", s.requireAdmin(s.getModerationLogHandler)).Methods("GET")
+	r.Use(s.signatureMiddleware)
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.handleError(w, r, CodeNotFound, "Not found", http.StatusNotFound)
+	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.handleError(w, r, CodeMethodNotAllowed, "Method not allowed", http.StatusMethodNotAllowed)
+	})
+	return r
+}
+
+func (s *Server) createStatusUpdate(w http.ResponseWriter, r *http.Request) {
+	if !s.allowRequest(w) {
+		s.handleError(w, r, CodeRateLimited, "Rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	var update StatusUpdate
+	if !s.decodeJSON(w, r, &update, StatusUpdateRequestMaxSize) {
+		return
+	}
+	// Only federation sets where a post came from.
+	update.Origin = ""
+	canonicalizeStatusUpdate(&update)
+
+	difficulty, err := s.proofOfWorkDifficulty(update.Pubkey)
+	if err != nil {
+		s.handleError(w, r, CodeInternal, "Error checking pubkey", http.StatusInternalServerError)
+		return
+	}
+	if err := validateStatusUpdate(update, difficulty); err != nil {
+		s.rejectStatusUpdate(w, r, update, err, http.StatusBadRequest)
+		return
+	}
+	update.Nonce = ""
+
+	if err := s.checkPubkeyAccess(update.Pubkey); err != nil {
+		if _, ok := err.(*ValidationError); ok {
+			s.rejectStatusUpdate(w, r, update, err, http.StatusForbidden)
+		} else {
+			s.handleAccessError(w, r, err)
+		}
+		return
+	}
+
+	if err := s.checkAttachments(update); err != nil {
+		if _, ok := err.(*ValidationError); ok {
+			s.rejectStatusUpdate(w, r, update, err, http.StatusBadRequest)
+		} else {
+			s.handleError(w, r, CodeInternal, "Error checking attachments", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := s.checkContent(update); err != nil {
+		if _, ok := err.(*ValidationError); ok {
+			s.rejectStatusUpdate(w, r, update, err, http.StatusBadRequest)
+		} else {
+			s.log.ErrorContext(r.Context(), "error filtering status update", "error", err)
+			s.handleError(w, r, CodeInternal, "Error checking status update", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if len(key) > IdempotencyKeyMaxSize {
+		s.writeError(w, r, ErrorResponse{
+			Code:    CodeInvalidParameter,
+			Message: fmt.Sprintf("%s must be at most %d characters", IdempotencyKeyHeader, IdempotencyKeyMaxSize),
+			Field:   IdempotencyKeyHeader,
+		}, http.StatusBadRequest)
+		return
+	}
+	if key != "" {
+		existing, found, err := s.idempotentStatusUpdate(update.Pubkey, key)
+		if err != nil {
+			s.handleError(w, r, CodeInternal, "Error checking idempotency key", http.StatusInternalServerError)
+			return
+		}
+		if found && existing.Signature != update.Signature {
+			s.writeError(w, r, ErrorResponse{
+				Code:    CodeIdempotencyKeyReused,
+				Message: fmt.Sprintf("%s was already used for a different status update", IdempotencyKeyHeader),
+				Field:   IdempotencyKeyHeader,
+			}, http.StatusUnprocessableEntity)
+			return
+		}
+		if found {
+			s.replayStatusUpdate(w, r, existing)
+			return
+		}
+	}
+
+	update.Timestamp = time.Now().UnixNano()
+	err = s.store.AddStatusUpdate(&update)
+	if errors.Is(err, ErrDuplicateStatusUpdate) {
+		// A retry of a post that made it the first time.
+		existing, err := s.store.GetStatusUpdateBySignature(update.Signature)
+		if err != nil {
+			s.handleError(w, r, CodeInternal, "Error retrieving status update", http.StatusInternalServerError)
+			return
+		}
+		s.replayStatusUpdate(w, r, existing)
+		return
+	}
+	if err != nil {
+		s.handleError(w, r, CodeInternal, "Error adding status update", http.StatusInternalServerError)
+		return
+	}
+
+	if key != "" {
+		if err := s.store.AddIdempotencyKey(update.Pubkey, key, update.ID); err != nil {
+			s.log.ErrorContext(r.Context(), "error recording idempotency key", "error", err)
+		}
+	}
+
+	s.metrics.successfulRequests.Add(1)
+	s.render(w, r, http.StatusOK, update)
+}
+
+// allowRequest takes a token from the rate limiter and reports the limiter's
+// state in X-RateLimit-* headers, plus Retry-After when it is exhausted.
+func (s *Server) allowRequest(w http.ResponseWriter) bool {
+	return s.allow(w, s.limiter)
+}
+
+// allow is allowRequest for any limiter.
+func (s *Server) allow(w http.ResponseWriter, limiter *rate.Limiter) bool {
+	allowed := limiter.Allow()
+
+	tokens := limiter.Tokens()
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limiter.Burst()))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(max(int(tokens), 0)))
+	if !allowed && limiter.Limit() > 0 {
+		wait := math.Ceil((1 - tokens) / float64(limiter.Limit()))
+		w.Header().Set("Retry-After", strconv.Itoa(max(int(wait), 1)))
+	}
+	return allowed
+}
+
+// keyLimiter keeps a rate limiter per key. Limiters that have refilled
+// are dropped once keyLimitersMax are held, as they are no different from
+// new ones.
+type keyLimiter struct {
+	mu       sync.Mutex
+	limit    rate.Limit
+	burst    int
+	limiters map[string]*rate.Limiter
+}
+
+const keyLimitersMax = 10000
+
+func (l *keyLimiter) get(key string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limiters == nil {
+		l.limiters = map[string]*rate.Limiter{}
+	}
+	limiter, ok := l.limiters[key]
+	if ok {
+		return limiter
+	}
+	if len(l.limiters) >= keyLimitersMax {
+		for k, limiter := range l.limiters {
+			if limiter.Tokens() >= float64(l.burst) {
+				delete(l.limiters, k)
+			}
+		}
+	}
+	limiter = rate.NewLimiter(l.limit, l.burst)
+	l.limiters[key] = limiter
+	return limiter
+}
+
+// idempotentStatusUpdate looks up the post an earlier request with the same
+// Idempotency-Key created. A key whose post has since been quarantined is
+// treated as unused.
+func (s *Server) idempotentStatusUpdate(pubkey, key string) (StatusUpdate, bool, error) {
+	id, err := s.store.GetIdempotencyKey(pubkey, key)
+	if err != nil || id == 0 {
+		return StatusUpdate{}, false, err
+	}
+	update, err := s.store.GetStatusUpdate(id)
+	if err == sql.ErrNoRows {
+		return StatusUpdate{}, false, nil
+	}
+	return update, err == nil, err
+}
+
+// replayStatusUpdate answers a repeated POST /status with the post that is
+// already stored, as if it had just been created.
+func (s *Server) replayStatusUpdate(w http.ResponseWriter, r *http.Request, update StatusUpdate) {
+	s.metrics.successfulRequests.Add(1)
+
+	w.Header().Set("Idempotent-Replayed", "true")
+	s.render(w, r, http.StatusOK, update)
+}
+
+func (s *Server) getStatusUpdatesByPubkey(w http.ResponseWriter, r *http.Request) {
+	pubkeyStr := strings.ToLower(mux.Vars(r)["pubkey"])
+	if len(pubkeyStr) != PubkeyMaxSize*2 {
+		s.writeError(w, r, ErrorResponse{Code: CodeInvalidPubkey, Message: "Invalid public key", Field: "pubkey"}, http.StatusBadRequest)
+		return
+	}
+
+	s.renderFeed(w, r, func(fn func(StatusUpdate) error) error {
+		return s.store.ForEachFeedStatusUpdate(pubkeyStr, fn)
+	})
+}
+
+func (s *Server) getAllStatusUpdates(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("since") {
+		s.getStatusUpdatesSince(w, r)
+		return
+	}
+
+	s.renderFeed(w, r, func(fn func(StatusUpdate) error) error {
+		return s.store.ForEachFeedStatusUpdate("", fn)
+	})
+}
+
+// getStatusUpdatesSince serves GET /status?since=<id>[&limit=<n>], a page
+// of posts in id order used by peers to follow this instance's feed.
+func (s *Server) getStatusUpdatesSince(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	since, err := strconv.Atoi(query.Get("since"))
+	if err != nil || since < 0 {
+		s.writeError(w, r, ErrorResponse{Code: CodeInvalidParameter, Message: "Invalid since cursor", Field: "since"}, http.StatusBadRequest)
+		return
+	}
+
+	limit := FeedPageSize
+	if query.Has("limit") {
+		limit, err = strconv.Atoi(query.Get("limit"))
+		if err != nil || limit < 1 || limit > FeedMaxPageSize {
+			s.writeError(w, r, ErrorResponse{
+				Code:    CodeInvalidParameter,
+				Message: fmt.Sprintf("limit must be between 1 and %d", FeedMaxPageSize),
+				Field:   "limit",
+			}, http.StatusBadRequest)
+			return
+		}
+	}
+
+	s.renderFeed(w, r, func(fn func(StatusUpdate) error) error {
+		updates, err := s.store.GetStatusUpdatesSince(since, limit)
+		if err != nil {
+			return err
+		}
+		for _, update := range updates {
+			if err := fn(update); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Server) getStatisticsHandler(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.store.GetLatestStatistics()
+	if err != nil {
+		s.handleError(w, r, CodeInternal, "Error retrieving statistics", http.StatusInternalServerError)
+		return
+	}
+
+	s.render(w, r, http.StatusOK, stats)
+}
+
+// checkReceivedStatusUpdate runs the access, attachment and content checks
+// of POST /status on a post that arrived through a peer or an archive, so
+// those cannot be used to get around them. Refusals are *ValidationErrors.
+func (s *Server) checkReceivedStatusUpdate(update StatusUpdate) error {
+	if err := s.checkPubkeyAccess(update.Pubkey); err != nil {
+		return err
+	}
+	if err := s.checkAttachments(update); err != nil {
+		return err
+	}
+	return s.checkContent(update)
+}
+
+// canonicalizeStatusUpdate lowercases the hex pubkey and signature. Hex
+// decoding ignores case, so without it the same post could be stored again,
+// or escape a ban, just by changing the case of its hex.
+func canonicalizeStatusUpdate(update *StatusUpdate) {
+	update.Pubkey = strings.ToLower(update.Pubkey)
+	update.Signature = strings.ToLower(update.Signature)
+}
+
+// validateStatusUpdate returns a *ValidationError for the first problem it
+// finds. The body and link are checked and stored exactly as signed: they
+// are plain text, and whoever renders them as HTML must escape them.
+func validateStatusUpdate(update StatusUpdate, difficulty int) error {
+	if update.Body == "" {
+		return &ValidationError{Code: CodeBodyRequired, Field: "body", Message: "body cannot be empty"}
+	}
+
+	if len(update.Body) > BodyMaxSize {
+		return &ValidationError{Code: CodeBodyTooLong, Field: "body", Message: fmt.Sprintf("body exceeds maximum size of %d characters", BodyMaxSize)}
+	}
+
+	if update.Link != "" && len(update.Link) > LinkMaxSize {
+		return &ValidationError{Code: CodeLinkTooLong, Field: "link", Message: fmt.Sprintf("link exceeds maximum size of %d characters", LinkMaxSize)}
+	}
+
+	if err := validateAttachments(update.Attachments); err != nil {
+		return err
+	}
+
+	// Hashing is far cheaper than verifying a signature, so a flood of
+	// posts without work is turned away first.
+	if err := verifyProofOfWork(update, difficulty); err != nil {
+		return err
+	}
+
+	return verifyStatusUpdateSignature(update)
+}
+
+func verifyStatusUpdateSignature(update StatusUpdate) error {
+	if len(update.Pubkey) != PubkeyMaxSize*2 {
+		return &ValidationError{Code: CodeInvalidPubkey, Field: "pubkey", Message: "invalid pubkey length"}
+	}
+
+	if len(update.Signature) != SignatureMaxSize*2 {
+		return &ValidationError{Code: CodeInvalidSignature, Field: "signature", Message: "invalid signature length"}
+	}
+
+	pubkey, err := hex.DecodeString(update.Pubkey)
+	if err != nil {
+		return &ValidationError{Code: CodeInvalidPubkey, Field: "pubkey", Message: "invalid pubkey format"}
+	}
+
+	signature, err := hex.DecodeString(update.Signature)
+	if err != nil {
+		return &ValidationError{Code: CodeInvalidSignature, Field: "signature", Message: "invalid signature format"}
+	}
+
+	if !sdk.Verify(pubkey, signature, update.Body, update.Link, update.Attachments...) {
+		return &ValidationError{Code: CodeSignatureMismatch, Field: "signature", Message: "unauthorized: signature verification failed"}
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package server
+
+import "fmt"
+
+type AuditFailure struct {
+	ID     int    `json:"id"`
+	Pubkey string `json:"pubkey"`
+	Reason string `json:"reason"`
+}
+
+type AuditReport struct {
+	Checked     int            `json:"checked"`
+	Failed      []AuditFailure `json:"failed"`
+	Quarantined int            `json:"quarantined"`
+}
+
+// AuditStatusUpdates re-checks the signature of every stored post with the
+// same message construction used by validateStatusUpdate.
+// When quarantine is set, failing rows are moved out of status_updates.
+func AuditStatusUpdates(store Store, quarantine bool) (AuditReport, error) {
+	report := AuditReport{Failed: []AuditFailure{}}
+
+	err := store.ForEachStatusUpdate(func(update StatusUpdate) error {
+		report.Checked++
+		if err := verifyStatusUpdateSignature(update); err != nil {
+			report.Failed = append(report.Failed, AuditFailure{ID: update.ID, Pubkey: update.Pubkey, Reason: err.Error()})
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("error reading status updates: %v", err)
+	}
+
+	if !quarantine {
+		return report, nil
+	}
+
+	for _, failure := range report.Failed {
+		if err := store.QuarantineStatusUpdate(failure.ID, failure.Reason); err != nil {
+			return report, fmt.Errorf("error quarantining post %d: %v", failure.ID, err)
+		}
+		report.Quarantined++
+	}
+
+	return report, nil
+}
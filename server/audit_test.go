@@ -0,0 +1,65 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/donuts-are-good/postshortly/sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditStatusUpdates(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+
+	_, privkey, _ := sdk.GenerateKey()
+	signed := sdk.StatusUpdate{Body: "Test body", Link: "http://example.com"}
+	sdk.SignStatusUpdate(privkey, &signed)
+
+	good := StatusUpdate{Timestamp: time.Now().UnixNano(), Body: signed.Body, Link: signed.Link, Pubkey: signed.Pubkey, Signature: signed.Signature}
+	assert.NoError(t, srv.store.AddStatusUpdate(&good))
+
+	// The tampered copy carries a signature made for a different body.
+	other := sdk.StatusUpdate{Body: "Other body"}
+	sdk.SignStatusUpdate(privkey, &other)
+	tampered := good
+	tampered.Body = "Tampered body"
+	tampered.Signature = other.Signature
+	assert.NoError(t, srv.store.AddStatusUpdate(&tampered))
+
+	bogus := StatusUpdate{
+		Timestamp: time.Now().UnixNano(),
+		Body:      "Test body",
+		Pubkey:    strings.Repeat("a", PubkeyMaxSize*2),
+		Signature: strings.Repeat("z", SignatureMaxSize*2),
+	}
+	assert.NoError(t, srv.store.AddStatusUpdate(&bogus))
+
+	report, err := AuditStatusUpdates(srv.store, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Checked)
+	assert.Len(t, report.Failed, 2)
+	assert.Equal(t, tampered.ID, report.Failed[0].ID)
+	assert.Equal(t, bogus.ID, report.Failed[1].ID)
+	assert.Equal(t, "invalid signature format", report.Failed[1].Reason)
+	assert.Zero(t, report.Quarantined)
+
+	report, err = AuditStatusUpdates(srv.store, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Quarantined)
+
+	updates, err := srv.store.GetAllStatusUpdates()
+	assert.NoError(t, err)
+	assert.Len(t, updates, 1)
+	assert.Equal(t, good.ID, updates[0].ID)
+
+	var quarantined int
+	assert.NoError(t, srv.store.(*sqliteStore).db.Get(&quarantined, "SELECT COUNT(*) FROM quarantined_updates"))
+	assert.Equal(t, 2, quarantined)
+
+	report, err = AuditStatusUpdates(srv.store, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Checked)
+	assert.Empty(t, report.Failed)
+}
@@ -0,0 +1,277 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	snapshotPrefix     = "postshortly-"
+	snapshotSuffix     = ".sqlite.db"
+	snapshotTimeFormat = "20060102T150405.000Z"
+)
+
+// Snapshot is a backup file in the backup directory.
+type Snapshot struct {
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`
+	Created int64  `json:"created"`
+}
+
+// CreateSnapshot backs the store up into dir as postshortly-<time>.sqlite.db
+// and then deletes all but the newest keep snapshots. A keep of zero or less
+// keeps every snapshot.
+func CreateSnapshot(store Store, dir string, keep int) (Snapshot, error) {
+	return createSnapshot(store, dir, keep, time.Now())
+}
+
+func createSnapshot(store Store, dir string, keep int, now time.Time) (Snapshot, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return Snapshot{}, fmt.Errorf("error creating backup directory: %v", err)
+	}
+
+	name := snapshotPrefix + now.UTC().Format(snapshotTimeFormat) + snapshotSuffix
+	target := filepath.Join(dir, name)
+	if _, err := os.Stat(target); err == nil {
+		return Snapshot{}, fmt.Errorf("snapshot %s already exists", name)
+	}
+
+	// Back up under a temporary name so a snapshot cut short by a crash is
+	// never listed or restored.
+	tmp := target + ".tmp"
+	os.Remove(tmp)
+	if err := store.Backup(tmp); err != nil {
+		os.Remove(tmp)
+		return Snapshot{}, fmt.Errorf("error backing up database: %w", err)
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		return Snapshot{}, fmt.Errorf("error saving snapshot: %v", err)
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if err := pruneSnapshots(dir, keep); err != nil {
+		return Snapshot{}, err
+	}
+	return Snapshot{Name: name, Size: info.Size(), Created: now.Unix()}, nil
+}
+
+// ListSnapshots returns the snapshots in dir, newest first. A missing
+// directory has no snapshots.
+func ListSnapshots(dir string) ([]Snapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Snapshot{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading backup directory: %v", err)
+	}
+
+	snapshots := []Snapshot{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, snapshotPrefix) || !strings.HasSuffix(name, snapshotSuffix) {
+			continue
+		}
+		created, err := time.Parse(snapshotTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, snapshotPrefix), snapshotSuffix))
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, Snapshot{Name: name, Size: info.Size(), Created: created.Unix()})
+	}
+
+	// The timestamp format sorts lexically.
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Name > snapshots[j].Name
+	})
+	return snapshots, nil
+}
+
+func pruneSnapshots(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	snapshots, err := ListSnapshots(dir)
+	if err != nil {
+		return err
+	}
+	for i := keep; i < len(snapshots); i++ {
+		if err := os.Remove(filepath.Join(dir, snapshots[i].Name)); err != nil {
+			return fmt.Errorf("error removing old snapshot: %v", err)
+		}
+	}
+	return nil
+}
+
+// RestoreSnapshot replaces the SQLite database at database with a snapshot
+// after checking that the snapshot is intact and not from a newer build.
+// The server must not be running against database while it is restored.
+func RestoreSnapshot(snapshot, database string) error {
+	if strings.Contains(database, "://") {
+		return ErrBackupUnsupported
+	}
+	if err := checkSnapshot(snapshot); err != nil {
+		return err
+	}
+
+	// Copy next to the database and rename over it, so the database is
+	// either the old one or the complete snapshot.
+	tmp := database + ".restore.tmp"
+	if err := copyFile(snapshot, tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error copying snapshot: %v", err)
+	}
+	// A leftover write-ahead log belongs to the old database and would be
+	// replayed on top of the snapshot.
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(database + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := os.Rename(tmp, database); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error replacing database: %v", err)
+	}
+	return nil
+}
+
+// checkSnapshot opens a snapshot read-only and runs SQLite's integrity
+// check on it.
+func checkSnapshot(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	conn, err := sqlx.Connect("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("error opening snapshot: %v", err)
+	}
+	defer conn.Close()
+
+	var result string
+	if err := conn.Get(&result, "PRAGMA integrity_check"); err != nil {
+		return fmt.Errorf("error checking snapshot: %v", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("snapshot is damaged: %s", result)
+	}
+
+	var version int
+	if err := conn.Get(&version, "SELECT COALESCE(MAX(version), 0) FROM schema_version"); err != nil {
+		return fmt.Errorf("%s is not a postshortly snapshot: %v", path, err)
+	}
+	migrations, err := loadMigrations("sqlite")
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return errSchemaTooNew(version, len(migrations))
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// runBackups takes a snapshot every BackupInterval until ctx is cancelled.
+func (s *Server) runBackups(ctx context.Context) {
+	if s.config.BackupInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(s.config.BackupInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := CreateSnapshot(s.store, s.config.BackupDir, s.config.BackupKeep); err != nil {
+			s.log.Error("error backing up database", "error", err)
+		}
+	}
+}
+
+func (s *Server) createBackupHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := CreateSnapshot(s.store, s.config.BackupDir, s.config.BackupKeep)
+	if errors.Is(err, ErrBackupUnsupported) {
+		s.handleError(w, r, CodeNotImplemented, ErrBackupUnsupported.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		s.handleError(w, r, CodeInternal, "Error creating backup", http.StatusInternalServerError)
+		return
+	}
+
+	s.render(w, r, http.StatusCreated, snapshot)
+}
+
+func (s *Server) getBackupsHandler(w http.ResponseWriter, r *http.Request) {
+	snapshots, err := ListSnapshots(s.config.BackupDir)
+	if err != nil {
+		s.handleError(w, r, CodeInternal, "Error listing backups", http.StatusInternalServerError)
+		return
+	}
+
+	s.render(w, r, http.StatusOK, snapshots)
+}
+
+// downloadBackupHandler serves a snapshot file. Only names that appear in
+// the listing are served, so the name cannot reach outside the directory.
+func (s *Server) downloadBackupHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	snapshots, err := ListSnapshots(s.config.BackupDir)
+	if err != nil {
+		s.handleError(w, r, CodeInternal, "Error listing backups", http.StatusInternalServerError)
+		return
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.Name == name {
+			w.Header().Set("Content-Type", "application/vnd.sqlite3")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+			http.ServeFile(w, r, filepath.Join(s.config.BackupDir, name))
+			return
+		}
+	}
+	s.handleError(w, r, CodeNotFound, "Backup not found", http.StatusNotFound)
+}
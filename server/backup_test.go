@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotRotation(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	dir := t.TempDir()
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		_, err := createSnapshot(srv.store, dir, 2, start.Add(time.Duration(i)*time.Hour))
+		require.NoError(t, err)
+	}
+	_, err := createSnapshot(srv.store, dir, 2, start.Add(3*time.Hour))
+	assert.Error(t, err, "snapshot names must not be reused")
+
+	snapshots, err := ListSnapshots(dir)
+	require.NoError(t, err)
+	require.Len(t, snapshots, 2)
+	assert.Equal(t, "postshortly-20240102T060405.000Z.sqlite.db", snapshots[0].Name)
+	assert.Equal(t, start.Add(2*time.Hour).Unix(), snapshots[1].Created)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "no temporary files are left behind")
+}
+
+func TestRestoreSnapshot(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+
+	store, err := OpenStore(path, true)
+	require.NoError(t, err)
+	post := testPost(strings.Repeat("a", PubkeyMaxSize*2), "kept")
+	require.NoError(t, store.AddStatusUpdate(&post))
+	snapshot, err := CreateSnapshot(store, filepath.Join(dir, "backups"), 0)
+	require.NoError(t, err)
+	post = testPost(strings.Repeat("a", PubkeyMaxSize*2), "lost")
+	require.NoError(t, store.AddStatusUpdate(&post))
+	require.NoError(t, store.Close())
+
+	require.NoError(t, RestoreSnapshot(filepath.Join(dir, "backups", snapshot.Name), path))
+
+	store, err = OpenStore(path, false)
+	require.NoError(t, err)
+	defer store.Close()
+	updates, err := store.GetAllStatusUpdates()
+	require.NoError(t, err)
+	require.Len(t, updates, 1)
+	assert.Equal(t, "kept", updates[0].Body)
+
+	damaged := filepath.Join(dir, "damaged.db")
+	require.NoError(t, os.WriteFile(damaged, []byte("not a database"), 0644))
+	assert.Error(t, RestoreSnapshot(damaged, path))
+}
+
+func TestBackupEndpoints(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	srv.config.AdminToken = testAdminToken
+	srv.config.BackupDir = t.TempDir()
+	router := srv.setupRouter()
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest("POST", "/backups", nil))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var created Snapshot
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&created))
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest("GET", "/backups", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var snapshots []Snapshot
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&snapshots))
+	assert.Equal(t, []Snapshot{created}, snapshots)
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest("GET", "/backups/"+created.Name, nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.EqualValues(t, created.Size, rr.Body.Len())
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest("GET", "/backups/postshortly-missing.sqlite.db", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/donuts-are-good/postshortly/sdk"
+)
+
+// Config holds the settings that can be changed without rebuilding. It is
+// read from a JSON file passed with 'postshortly serve -config' or built
+// by programs embedding the server.
+type Config struct {
+	// Database is the path of a SQLite file or a postgres:// URL.
+	Database string `json:"database"`
+	// AutoMigrate applies pending schema migrations at startup. When off,
+	// the server refuses to start until 'postshortly migrate' is run.
+	AutoMigrate bool `json:"auto_migrate"`
+	// Peers are base URLs of other postshortly instances to pull posts from.
+	Peers []string `json:"peers"`
+	// SyncInterval is how often peers are polled for new posts.
+	SyncInterval Duration `json:"sync_interval"`
+	// AdminToken enables the admin endpoints (webhooks, backups,
+	// moderation) for requests sending it as a bearer token.
+	AdminToken string `json:"admin_token"`
+	// OperatorKeys are hex ed25519 public keys whose signed requests are
+	// let into the admin endpoints.
+	OperatorKeys []string `json:"operator_keys"`
+	// BackupDir is where snapshots of a SQLite database are written.
+	BackupDir string `json:"backup_dir"`
+	// BackupInterval is how often a snapshot is taken; zero disables
+	// scheduled backups.
+	BackupInterval Duration `json:"backup_interval"`
+	// BackupKeep is how many snapshots are kept before the oldest are
+	// deleted; zero keeps them all.
+	BackupKeep int `json:"backup_keep"`
+	// CORS controls access to the API from web pages on other origins.
+	CORS CORSConfig `json:"cors"`
+	// Log controls the server log and the access log.
+	Log LogConfig `json:"log"`
+	// Access restricts which pubkeys may post and read.
+	Access AccessConfig `json:"access"`
+	// ProofOfWork makes posts carry a hashcash-style stamp.
+	ProofOfWork ProofOfWorkConfig `json:"proof_of_work"`
+	// Filters is the content policy applied to new posts.
+	Filters FilterConfig `json:"filters"`
+	// Media controls uploads of files posts can attach.
+	Media MediaConfig `json:"media"`
+}
+
+// DefaultConfig returns the settings used when no config file is given.
+func DefaultConfig() Config {
+	return Config{
+		Database:     dbFile,
+		AutoMigrate:  true,
+		SyncInterval: Duration(time.Minute),
+		BackupDir:    "backups",
+		BackupKeep:   7,
+		CORS: CORSConfig{
+			AllowedOrigins: []string{"*"},
+			AllowedHeaders: []string{
+				"Authorization", "Content-Type", IdempotencyKeyHeader, RequestIDHeader,
+				sdk.RequestKeyHeader, sdk.RequestTimestampHeader, sdk.RequestNonceHeader, sdk.RequestSignatureHeader,
+			},
+			MaxAge: Duration(10 * time.Minute),
+		},
+		Log: LogConfig{
+			Level:     slog.LevelInfo,
+			File:      "stderr",
+			AccessLog: "stdout",
+		},
+		Access: AccessConfig{
+			Mode:           AccessOpen,
+			ReloadInterval: Duration(10 * time.Second),
+		},
+		Media: MediaConfig{
+			MaxSize:       5 << 20,
+			QuotaPerKey:   50 << 20,
+			UnattachedTTL: Duration(24 * time.Hour),
+			AllowedTypes:  []string{"image/png", "image/jpeg", "image/gif", "image/webp"},
+			ThumbnailSize: 320,
+		},
+	}
+}
+
+// LoadConfig reads a config file on top of the defaults.
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("error reading config: %v", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("error parsing config: %v", err)
+	}
+	if cfg.SyncInterval <= 0 {
+		return cfg, fmt.Errorf("error parsing config: sync_interval must be positive")
+	}
+	for _, key := range cfg.OperatorKeys {
+		if raw, err := hex.DecodeString(key); err != nil || len(raw) != PubkeyMaxSize {
+			return cfg, fmt.Errorf("error parsing config: bad operator key %q", key)
+		}
+	}
+	switch cfg.Access.Mode {
+	case AccessOpen, AccessAllowlist, AccessPrivate:
+	default:
+		return cfg, fmt.Errorf("error parsing config: bad access mode %q", cfg.Access.Mode)
+	}
+	if cfg.Access.ReloadInterval <= 0 {
+		return cfg, fmt.Errorf("error parsing config: access.reload_interval must be positive")
+	}
+	for _, difficulty := range []int{cfg.ProofOfWork.Difficulty, cfg.ProofOfWork.EstablishedDifficulty} {
+		if difficulty < 0 || difficulty > sdk.ProofOfWorkMaxBits {
+			return cfg, fmt.Errorf("error parsing config: proof of work difficulty must be between 0 and %d", sdk.ProofOfWorkMaxBits)
+		}
+	}
+	if _, err := cfg.Filters.compile(nil); err != nil {
+		return cfg, fmt.Errorf("error parsing config: %v", err)
+	}
+	if cfg.Media.MaxSize < 0 || cfg.Media.ThumbnailSize < 0 || cfg.Media.QuotaPerKey < 0 {
+		return cfg, fmt.Errorf("error parsing config: media sizes cannot be negative")
+	}
+	if cfg.Media.UnattachedTTL <= 0 {
+		return cfg, fmt.Errorf("error parsing config: media.unattached_ttl must be positive")
+	}
+	for _, pattern := range cfg.CORS.AllowedOrigins {
+		if !validCORSPattern(pattern) {
+			return cfg, fmt.Errorf("error parsing config: bad CORS origin pattern %q", pattern)
+		}
+	}
+
+	return cfg, nil
+}
+
+// Duration is a time.Duration written as a string such as "30s" in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfigIntervals(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		json string
+		ok   bool
+	}{
+		{"defaults", `{}`, true},
+		{"sync", `{"sync_interval": "30s"}`, true},
+		{"zero sync", `{"sync_interval": "0s"}`, false},
+		{"negative sync", `{"sync_interval": "-1m"}`, false},
+		{"reload", `{"access": {"reload_interval": "1m"}}`, true},
+		{"zero reload", `{"access": {"reload_interval": "0s"}}`, false},
+		{"negative reload", `{"access": {"reload_interval": "-5s"}}`, false},
+		{"zero media ttl", `{"media": {"unattached_ttl": "0s"}}`, false},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "config.json")
+		require.NoError(t, os.WriteFile(path, []byte(tt.json), 0o600))
+		_, err := LoadConfig(path)
+		if tt.ok {
+			assert.NoError(t, err, tt.name)
+		} else {
+			assert.ErrorContains(t, err, "error parsing config", tt.name)
+		}
+	}
+}
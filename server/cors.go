@@ -0,0 +1,136 @@
+package server
+
+import (
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// CORSConfig controls which web pages may call the API from a browser.
+type CORSConfig struct {
+	// AllowedOrigins lists origins such as "https://app.example.com".
+	// Patterns may use * for a run of characters without a slash, as in
+	// "https://*.example.com"; a lone "*" allows every origin.
+	AllowedOrigins []string `json:"allowed_origins"`
+	// AllowedHeaders are the request headers browsers may send.
+	AllowedHeaders []string `json:"allowed_headers"`
+	// MaxAge is how long browsers may cache a preflight response.
+	MaxAge Duration `json:"max_age"`
+}
+
+// corsExposedHeaders are the response headers scripts may read.
+var corsExposedHeaders = []string{
+	RequestIDHeader,
+	"Idempotent-Replayed",
+	"Retry-After",
+	"X-RateLimit-Limit",
+	"X-RateLimit-Remaining",
+}
+
+// validCORSPattern reports whether an AllowedOrigins entry is usable.
+func validCORSPattern(pattern string) bool {
+	_, err := path.Match(pattern, "")
+	return err == nil
+}
+
+type corsPolicy struct {
+	config CORSConfig
+	router *mux.Router
+	// methods are all the methods any route is registered for.
+	methods []string
+}
+
+func newCORSPolicy(cfg CORSConfig, router *mux.Router) *corsPolicy {
+	seen := map[string]bool{}
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		methods, _ := route.GetMethods()
+		for _, method := range methods {
+			seen[method] = true
+		}
+		return nil
+	})
+
+	c := &corsPolicy{config: cfg, router: router}
+	for method := range seen {
+		c.methods = append(c.methods, method)
+	}
+	sort.Strings(c.methods)
+	return c
+}
+
+func (c *corsPolicy) allowOrigin(origin string) bool {
+	for _, pattern := range c.config.AllowedOrigins {
+		if pattern == "*" {
+			return true
+		}
+		if ok, _ := path.Match(pattern, origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// routeMethods returns the methods the router serves for the path of r, so
+// preflight responses only advertise what a request could actually use.
+func (c *corsPolicy) routeMethods(r *http.Request) []string {
+	var methods []string
+	for _, method := range c.methods {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		var match mux.RouteMatch
+		if c.router.Match(probe, &match) && match.MatchErr == nil {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// middleware applies the policy. It wraps the router from the outside:
+// mux only runs its own middleware for matched routes, and a preflight
+// OPTIONS request matches none.
+func (c *corsPolicy) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		if origin == "" || !c.allowOrigin(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(c.config.AllowedOrigins) == 1 && c.config.AllowedOrigins[0] == "*" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !preflight {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		methods := c.routeMethods(r)
+		if len(methods) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if len(c.config.AllowedHeaders) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.config.AllowedHeaders, ", "))
+		}
+		if c.config.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(time.Duration(c.config.MaxAge).Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newCORSTestServer(t *testing.T, origins ...string) *Server {
+	t.Helper()
+	srv := newTestServer(t)
+	srv.config.CORS.AllowedOrigins = origins
+	return srv
+}
+
+func preflight(handler http.Handler, path, origin, method string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodOptions, path, nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCORSPreflight(t *testing.T) {
+	t.Parallel()
+	srv := newCORSTestServer(t, "https://app.example.com")
+	handler := srv.Handler()
+
+	rr := preflight(handler, "/status", "https://app.example.com", http.MethodPost)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "https://app.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, POST", rr.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Authorization, Content-Type, Idempotency-Key, X-Request-ID, X-Postshortly-Key, X-Postshortly-Timestamp, X-Postshortly-Nonce, X-Postshortly-Request-Signature", rr.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", rr.Header().Get("Access-Control-Max-Age"))
+	assert.ElementsMatch(t, []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}, rr.Header().Values("Vary"))
+
+	rr = preflight(handler, "/webhooks/1", "https://app.example.com", http.MethodDelete)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "DELETE", rr.Header().Get("Access-Control-Allow-Methods"))
+
+	// Paths no route serves are left to the router.
+	rr = preflight(handler, "/nope", "https://app.example.com", http.MethodGet)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Methods"))
+}
+
+func TestCORSOrigins(t *testing.T) {
+	t.Parallel()
+	srv := newCORSTestServer(t, "https://*.example.com", "http://localhost:8080")
+	handler := srv.Handler()
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://app.example.com", true},
+		{"http://localhost:8080", true},
+		{"https://example.com", false},
+		{"https://a.b.example.com", true},
+		{"http://app.example.com", false},
+		{"https://evil.com", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/status", nil)
+		req.Header.Set("Origin", tt.origin)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code, tt.origin)
+		assert.Contains(t, rr.Header().Values("Vary"), "Origin", tt.origin)
+		if tt.allowed {
+			assert.Equal(t, tt.origin, rr.Header().Get("Access-Control-Allow-Origin"), tt.origin)
+			assert.Contains(t, rr.Header().Get("Access-Control-Expose-Headers"), "X-RateLimit-Remaining", tt.origin)
+		} else {
+			assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"), tt.origin)
+			assert.Empty(t, rr.Header().Get("Access-Control-Expose-Headers"), tt.origin)
+		}
+	}
+
+	rr := preflight(handler, "/status", "https://evil.com", http.MethodPost)
+	assert.NotEqual(t, http.StatusNoContent, rr.Code)
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Methods"))
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	t.Parallel()
+	srv := newCORSTestServer(t, "*")
+	srv.config.CORS.MaxAge = Duration(time.Hour)
+	handler := srv.Handler()
+
+	rr := preflight(handler, "/status", "https://anywhere.test", http.MethodGet)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "*", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "3600", rr.Header().Get("Access-Control-Max-Age"))
+}
@@ -0,0 +1,21 @@
+package server
+
+import (
+	_ "embed"
+	"net/http"
+	"strconv"
+)
+
+// dashboardHTML is a self-contained page that polls GET /stats and charts
+// the results, so it needs nothing but the API itself.
+//
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+func (s *Server) dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(dashboardHTML)))
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self'")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(dashboardHTML)
+}
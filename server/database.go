@@ -0,0 +1,827 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const dbFile = "postshortly.sqlite.db"
+
+// Store persists posts, statistics and everything built on top of them.
+// Handlers and workers only talk to the database through it, so every
+// backend has to pass the conformance tests in database_test.go.
+type Store interface {
+	AddStatusUpdate(update *StatusUpdate) error
+	StatusUpdateExists(pubkey, signature string) (bool, error)
+	GetStatusUpdate(id int) (StatusUpdate, error)
+	GetStatusUpdateBySignature(signature string) (StatusUpdate, error)
+	GetStatusUpdatesByPubkey(pubkey string) ([]StatusUpdate, error)
+	GetAllStatusUpdates() ([]StatusUpdate, error)
+	GetStatusUpdatesSince(since, limit int) ([]StatusUpdate, error)
+	ForEachStatusUpdate(fn func(StatusUpdate) error) error
+	ForEachFeedStatusUpdate(pubkey string, fn func(StatusUpdate) error) error
+	QuarantineStatusUpdate(id int, reason string) error
+
+	UpdateStatistics(stats Statistics) error
+	GetLatestStatistics() (Statistics, error)
+
+	GetIdempotencyKey(pubkey, key string) (int, error)
+	AddIdempotencyKey(pubkey, key string, statusID int) error
+
+	GetPeerCursor(peer string) (int, error)
+	SetPeerCursor(peer string, lastID int) error
+
+	AddWebhook(hook *Webhook) error
+	GetWebhooks() ([]Webhook, error)
+	GetWebhook(id int) (Webhook, error)
+	DeleteWebhook(id int) (bool, error)
+	GetDueWebhookDeliveries(now time.Time, limit int) ([]WebhookDelivery, error)
+	ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error)
+	UpdateWebhookDelivery(delivery WebhookDelivery) error
+	GetWebhookDeliveries(webhookID, limit int) ([]WebhookDelivery, error)
+
+	SchemaVersion() (current, latest int, err error)
+	Migrate() ([]string, error)
+	// Moderate applies a moderation action and records it in the
+	// moderation log. Hiding a post that does not exist, or undoing an
+	// action that is not in effect, returns sql.ErrNoRows.
+	Moderate(action *ModerationAction) error
+	IsPubkeyBanned(pubkey string) (bool, error)
+	GetBans() ([]Ban, error)
+	IsPubkeyAllowed(pubkey string) (bool, error)
+	GetAllowedPubkeys() ([]AllowedPubkey, error)
+	// BodyPostedByOthers reports whether a pubkey other than pubkey posted
+	// body at or after the timestamp since.
+	BodyPostedByOthers(body, pubkey string, since int64) (bool, error)
+	// GetPubkeyHistory summarizes the visible posts of pubkey.
+	GetPubkeyHistory(pubkey string) (PubkeyHistory, error)
+	GetModerationLog(limit int) ([]ModerationAction, error)
+	// AddRejection records a refused post, keeping only the newest
+	// RejectionsKept.
+	AddRejection(rejection *Rejection) error
+	GetRejections(limit int) ([]Rejection, error)
+
+	// AddMessage stores a direct message, dropping messages older than
+	// MessageTTL. It returns ErrMailboxFull when the recipient already has
+	// MailboxMaxMessages, and ErrTooManyFromSender when
+	// MailboxMaxPerSender of them have the message's SenderHash.
+	AddMessage(msg *Message) error
+	// GetMessages returns up to limit messages for recipient with an id
+	// greater than since, oldest first.
+	GetMessages(recipient string, since, limit int) ([]Message, error)
+	DeleteMessage(recipient string, id int) (bool, error)
+
+	// AddMedia records an uploaded file. Uploading the same bytes again
+	// keeps the first record.
+	AddMedia(media *Media) error
+	// GetMedia returns sql.ErrNoRows for hashes never uploaded.
+	GetMedia(hash string) (Media, error)
+	// MediaUsage returns the bytes of the files pubkey uploaded.
+	MediaUsage(pubkey string) (int64, error)
+	// DeleteUnattachedMedia deletes up to limit media records created
+	// before the Unix time before that no post attaches, and returns them
+	// so their files can be removed.
+	DeleteUnattachedMedia(before int64, limit int) ([]Media, error)
+
+	// Ping checks that the database can be reached.
+	Ping(ctx context.Context) error
+	// Backup writes a consistent snapshot of the database to a file that
+	// must not exist yet. Only SQLite supports it.
+	Backup(path string) error
+	Close() error
+}
+
+// ErrDuplicateStatusUpdate is returned by Store.AddStatusUpdate when a post
+// with the same signature is already stored.
+var ErrDuplicateStatusUpdate = errors.New("status update already exists")
+
+// ErrBackupUnsupported is returned by Store.Backup for databases that have
+// their own backup tools.
+var ErrBackupUnsupported = errors.New("backups are only supported for SQLite databases, use pg_dump for PostgreSQL")
+
+// OpenStore connects to a database and checks its schema, applying pending
+// migrations when autoMigrate is set.
+func OpenStore(database string, autoMigrate bool) (Store, error) {
+	s, err := ConnectStore(database)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSchema(s, autoMigrate); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// ConnectStore connects to the database named by a postgres:// URL or, for
+// anything else, the path of a SQLite file, without touching its schema.
+func ConnectStore(database string) (Store, error) {
+	if isPostgresURL(database) {
+		return newPostgresStore(database)
+	}
+	return newSQLiteStore(database)
+}
+
+func isPostgresURL(database string) bool {
+	return strings.HasPrefix(database, "postgres://") || strings.HasPrefix(database, "postgresql://")
+}
+
+// Hot queries, prepared once per store and reused.
+const (
+	insertStatusUpdateQuery = `
+		INSERT INTO status_updates (timestamp, body, link, pubkey, signature, origin, attachments)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (signature) DO NOTHING RETURNING id`
+	statusUpdatesByPubkeyQuery = "SELECT * FROM status_updates WHERE pubkey = ? AND " + visibleCondition + " ORDER BY timestamp DESC"
+	allStatusUpdatesQuery      = "SELECT * FROM status_updates WHERE " + visibleCondition + " ORDER BY timestamp DESC"
+	statusUpdatesSinceQuery    = "SELECT * FROM status_updates WHERE id > ? AND " + visibleCondition + " ORDER BY id LIMIT ?"
+	statusUpdateExistsQuery    = "SELECT EXISTS(SELECT 1 FROM status_updates WHERE pubkey = ? AND signature = ?)"
+)
+
+// visibleCondition leaves out posts a moderator hid and posts by banned
+// pubkeys. Feeds apply it; lookups of a single post by id or signature and
+// audits do not.
+const visibleCondition = `id NOT IN (SELECT status_id FROM hidden_status_updates)
+	AND pubkey NOT IN (SELECT pubkey FROM banned_pubkeys)`
+
+// sqlStore implements Store with queries both SQLite and PostgreSQL
+// understand. Queries are written with ? placeholders and rebound for the
+// driver in use.
+type sqlStore struct {
+	// db takes every write and anything that has to see its own writes.
+	db *sqlx.DB
+	// reader serves plain reads. It is the same pool as db except for
+	// SQLite files, where it is a separate read-only pool.
+	reader *sqlx.DB
+	// dialect selects the migrations in migrations/<dialect>.
+	dialect string
+
+	mu    sync.Mutex
+	stmts map[stmtKey]*sqlx.Stmt
+}
+
+type stmtKey struct {
+	db    *sqlx.DB
+	query string
+}
+
+func newSQLStore(db, reader *sqlx.DB, dialect string) *sqlStore {
+	return &sqlStore{db: db, reader: reader, dialect: dialect, stmts: map[stmtKey]*sqlx.Stmt{}}
+}
+
+// prepared returns query prepared on db, preparing it on first use.
+// Statements are prepared lazily because the tables may not exist until the
+// store has been migrated.
+func (s *sqlStore) prepared(db *sqlx.DB, query string) (*sqlx.Stmt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := stmtKey{db, query}
+	if stmt, ok := s.stmts[key]; ok {
+		return stmt, nil
+	}
+	stmt, err := db.Preparex(db.Rebind(query))
+	if err != nil {
+		return nil, err
+	}
+	s.stmts[key] = stmt
+	return stmt, nil
+}
+
+func (s *sqlStore) Ping(ctx context.Context) error {
+	var one int
+	return s.reader.GetContext(ctx, &one, "SELECT 1")
+}
+
+func (s *sqlStore) Backup(path string) error {
+	return ErrBackupUnsupported
+}
+
+func (s *sqlStore) Close() error {
+	s.mu.Lock()
+	var errs []error
+	for key, stmt := range s.stmts {
+		errs = append(errs, stmt.Close())
+		delete(s.stmts, key)
+	}
+	s.mu.Unlock()
+
+	errs = append(errs, s.db.Close())
+	if s.reader != s.db {
+		errs = append(errs, s.reader.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// AddStatusUpdate stores a post and queues a delivery for every webhook
+// interested in it, in one transaction. It returns ErrDuplicateStatusUpdate
+// without storing anything if the signature is already taken.
+func (s *sqlStore) AddStatusUpdate(update *StatusUpdate) error {
+	// Prepare before taking the writer connection for the transaction, as
+	// preparing needs a connection of its own.
+	insert, err := s.prepared(s.db, insertStatusUpdateQuery)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.Stmtx(insert).QueryRowx(update.Timestamp, update.Body, update.Link, update.Pubkey, update.Signature, update.Origin, update.Attachments).Scan(&update.ID)
+	if err == sql.ErrNoRows {
+		return ErrDuplicateStatusUpdate
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := enqueueWebhookDeliveries(tx, *update); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlStore) GetStatusUpdatesByPubkey(pubkey string) ([]StatusUpdate, error) {
+	stmt, err := s.prepared(s.reader, statusUpdatesByPubkeyQuery)
+	if err != nil {
+		return nil, err
+	}
+	var updates []StatusUpdate
+	err = stmt.Select(&updates, pubkey)
+	if err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
+
+func (s *sqlStore) GetAllStatusUpdates() ([]StatusUpdate, error) {
+	stmt, err := s.prepared(s.reader, allStatusUpdatesQuery)
+	if err != nil {
+		return nil, err
+	}
+	var updates []StatusUpdate
+	err = stmt.Select(&updates)
+	if err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
+
+// GetStatusUpdatesSince returns up to limit posts with an id greater than
+// since, oldest first, so callers can page through the table with the last
+// id they saw as a cursor.
+func (s *sqlStore) GetStatusUpdatesSince(since, limit int) ([]StatusUpdate, error) {
+	stmt, err := s.prepared(s.reader, statusUpdatesSinceQuery)
+	if err != nil {
+		return nil, err
+	}
+	var updates []StatusUpdate
+	err = stmt.Select(&updates, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
+
+func (s *sqlStore) GetStatusUpdate(id int) (StatusUpdate, error) {
+	var update StatusUpdate
+	err := s.db.Get(&update, s.db.Rebind("SELECT * FROM status_updates WHERE id = ?"), id)
+	return update, err
+}
+
+func (s *sqlStore) GetStatusUpdateBySignature(signature string) (StatusUpdate, error) {
+	var update StatusUpdate
+	err := s.db.Get(&update, s.db.Rebind("SELECT * FROM status_updates WHERE signature = ?"), signature)
+	return update, err
+}
+
+// GetIdempotencyKey returns the id of the post created with an
+// Idempotency-Key in the last IdempotencyKeyTTL, or 0 if there is none.
+func (s *sqlStore) GetIdempotencyKey(pubkey, key string) (int, error) {
+	var statusID int
+	err := s.db.Get(&statusID, s.db.Rebind(`
+		SELECT status_id FROM idempotency_keys WHERE pubkey = ? AND idempotency_key = ? AND created > ?
+	`), pubkey, key, time.Now().Add(-IdempotencyKeyTTL).Unix())
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return statusID, err
+}
+
+// AddIdempotencyKey records the post an Idempotency-Key created, replacing
+// an expired use of the same key, and forgets keys past IdempotencyKeyTTL.
+func (s *sqlStore) AddIdempotencyKey(pubkey, key string, statusID int) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if _, err := tx.Exec(tx.Rebind("DELETE FROM idempotency_keys WHERE created <= ?"), now.Add(-IdempotencyKeyTTL).Unix()); err != nil {
+		return err
+	}
+	_, err = tx.Exec(tx.Rebind(`
+		INSERT INTO idempotency_keys (pubkey, idempotency_key, status_id, created) VALUES (?, ?, ?, ?)
+		ON CONFLICT (pubkey, idempotency_key) DO UPDATE SET status_id = excluded.status_id, created = excluded.created
+	`), pubkey, key, statusID, now.Unix())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) GetPeerCursor(peer string) (int, error) {
+	var lastID int
+	err := s.db.Get(&lastID, s.db.Rebind("SELECT last_id FROM peer_cursors WHERE peer = ?"), peer)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return lastID, err
+}
+
+func (s *sqlStore) SetPeerCursor(peer string, lastID int) error {
+	_, err := s.db.Exec(s.db.Rebind(`
+		INSERT INTO peer_cursors (peer, last_id, last_sync) VALUES (?, ?, ?)
+		ON CONFLICT(peer) DO UPDATE SET last_id = excluded.last_id, last_sync = excluded.last_sync
+	`), peer, lastID, time.Now().Unix())
+	return err
+}
+
+func (s *sqlStore) StatusUpdateExists(pubkey, signature string) (bool, error) {
+	stmt, err := s.prepared(s.reader, statusUpdateExistsQuery)
+	if err != nil {
+		return false, err
+	}
+	var exists bool
+	err = stmt.Get(&exists, pubkey, signature)
+	return exists, err
+}
+
+// ForEachStatusUpdate calls fn for every stored post in id order without
+// loading the whole table into memory.
+func (s *sqlStore) ForEachStatusUpdate(fn func(StatusUpdate) error) error {
+	rows, err := s.reader.Queryx("SELECT * FROM status_updates ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var update StatusUpdate
+		if err := rows.StructScan(&update); err != nil {
+			return err
+		}
+		if err := fn(update); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ForEachFeedStatusUpdate calls fn for every post in feed order, newest
+// first, or only for posts by pubkey if it is not empty. Rows are read as
+// fn consumes them, so a long feed can be streamed.
+func (s *sqlStore) ForEachFeedStatusUpdate(pubkey string, fn func(StatusUpdate) error) error {
+	var rows *sqlx.Rows
+	var err error
+	if pubkey == "" {
+		rows, err = s.reader.Queryx(allStatusUpdatesQuery)
+	} else {
+		rows, err = s.reader.Queryx(s.reader.Rebind(statusUpdatesByPubkeyQuery), pubkey)
+	}
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var update StatusUpdate
+		if err := rows.StructScan(&update); err != nil {
+			return err
+		}
+		if err := fn(update); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// QuarantineStatusUpdate moves a post into quarantined_updates so it is no
+// longer served, keeping it around for inspection.
+func (s *sqlStore) QuarantineStatusUpdate(id int, reason string) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(tx.Rebind(`
+		INSERT INTO quarantined_updates (id, timestamp, body, link, pubkey, signature, attachments, reason, quarantined_at)
+		SELECT id, timestamp, body, link, pubkey, signature, attachments, CAST(? AS TEXT), CAST(? AS BIGINT) FROM status_updates WHERE id = ?
+	`), reason, time.Now().Unix(), id)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(tx.Rebind("DELETE FROM status_updates WHERE id = ?"), id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) AddWebhook(hook *Webhook) error {
+	hook.Created = time.Now().Unix()
+	return s.db.QueryRowx(s.db.Rebind(`
+		INSERT INTO webhooks (url, secret, pubkey, tag, created) VALUES (?, ?, ?, ?, ?) RETURNING id
+	`), hook.URL, hook.Secret, hook.Pubkey, hook.Tag, hook.Created).Scan(&hook.ID)
+}
+
+func (s *sqlStore) GetWebhooks() ([]Webhook, error) {
+	webhooks := []Webhook{}
+	err := s.db.Select(&webhooks, "SELECT * FROM webhooks ORDER BY id")
+	return webhooks, err
+}
+
+func (s *sqlStore) GetWebhook(id int) (Webhook, error) {
+	var hook Webhook
+	err := s.db.Get(&hook, s.db.Rebind("SELECT * FROM webhooks WHERE id = ?"), id)
+	return hook, err
+}
+
+// DeleteWebhook removes a webhook along with its queued and logged
+// deliveries. It reports whether the webhook existed.
+func (s *sqlStore) DeleteWebhook(id int) (bool, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(tx.Rebind("DELETE FROM webhooks WHERE id = ?"), id)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	if _, err := tx.Exec(tx.Rebind("DELETE FROM webhook_deliveries WHERE webhook_id = ?"), id); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func enqueueWebhookDeliveries(tx *sqlx.Tx, update StatusUpdate) error {
+	var webhooks []Webhook
+	if err := tx.Select(&webhooks, tx.Rebind("SELECT * FROM webhooks WHERE pubkey = '' OR pubkey = ? ORDER BY id"), update.Pubkey); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, hook := range webhooks {
+		if !hook.Matches(update) {
+			continue
+		}
+		payload, err := webhookPayload(update)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(tx.Rebind(`
+			INSERT INTO webhook_deliveries (webhook_id, post_id, payload, status, next_attempt, created, updated)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`), hook.ID, update.ID, payload, DeliveryPending, now, now, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDueWebhookDeliveries returns pending deliveries whose next attempt is
+// at or before now, oldest first.
+func (s *sqlStore) GetDueWebhookDeliveries(now time.Time, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := s.db.Select(&deliveries, s.db.Rebind(`
+		SELECT * FROM webhook_deliveries
+		WHERE status = ? AND next_attempt <= ?
+		ORDER BY next_attempt, id LIMIT ?
+	`), DeliveryPending, now.Unix(), limit)
+	return deliveries, err
+}
+
+// ClaimWebhookDeliveries returns due deliveries like GetDueWebhookDeliveries
+// and, in the same statement, moves their next attempt to now+lease, so
+// another worker polling the same database doesn't send them as well.
+func (s *sqlStore) ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	lock := ""
+	if s.dialect == "postgres" {
+		lock = "FOR UPDATE SKIP LOCKED"
+	}
+	var deliveries []WebhookDelivery
+	err := s.db.Select(&deliveries, s.db.Rebind(`
+		UPDATE webhook_deliveries SET next_attempt = ?, updated = ?
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = ? AND next_attempt <= ?
+			ORDER BY next_attempt, id LIMIT ? `+lock+`
+		)
+		RETURNING *
+	`), now.Add(lease).Unix(), time.Now().Unix(), DeliveryPending, now.Unix(), limit)
+	// RETURNING doesn't keep the order of the subquery.
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID < deliveries[j].ID })
+	return deliveries, err
+}
+
+func (s *sqlStore) UpdateWebhookDelivery(delivery WebhookDelivery) error {
+	_, err := s.db.Exec(s.db.Rebind(`
+		UPDATE webhook_deliveries
+		SET status = ?, attempts = ?, next_attempt = ?, response_code = ?, last_error = ?, updated = ?
+		WHERE id = ?
+	`), delivery.Status, delivery.Attempts, delivery.NextAttempt, delivery.ResponseCode,
+		delivery.LastError, time.Now().Unix(), delivery.ID)
+	return err
+}
+
+func (s *sqlStore) GetWebhookDeliveries(webhookID, limit int) ([]WebhookDelivery, error) {
+	deliveries := []WebhookDelivery{}
+	err := s.db.Select(&deliveries, s.db.Rebind(`
+		SELECT * FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT ?
+	`), webhookID, limit)
+	return deliveries, err
+}
+
+func (s *sqlStore) UpdateStatistics(stats Statistics) error {
+	_, err := s.db.Exec(s.db.Rebind(`
+		INSERT INTO statistics (
+			timestamp, total_posts, unique_pubkeys, successful_requests, 
+			failed_requests, total_requests, average_posts_per_pubkey, 
+			most_recent_post_timestamp, oldest_post_timestamp, 
+			rate_limit_requests_per_second
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`), time.Now().Unix(), stats.TotalPosts, stats.UniquePubkeys, stats.SuccessfulRequests,
+		stats.FailedRequests, stats.TotalRequests, stats.AveragePostsPerPubkey,
+		stats.MostRecentPostTimestamp, stats.OldestPostTimestamp,
+		stats.RateLimitRequestsPerSecond)
+	return err
+}
+
+func (s *sqlStore) GetLatestStatistics() (Statistics, error) {
+	var stats Statistics
+	err := s.db.Get(&stats, "SELECT * FROM statistics ORDER BY timestamp DESC, id DESC LIMIT 1")
+	return stats, err
+}
+
+func (s *sqlStore) Moderate(action *ModerationAction) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	action.Created = time.Now().Unix()
+	var result sql.Result
+	switch action.Action {
+	case ActionHide:
+		var exists bool
+		if err := tx.Get(&exists, tx.Rebind("SELECT EXISTS(SELECT 1 FROM status_updates WHERE id = ?)"), action.Target); err != nil {
+			return err
+		}
+		if !exists {
+			return sql.ErrNoRows
+		}
+		result, err = tx.Exec(tx.Rebind(`
+			INSERT INTO hidden_status_updates (status_id, reason, created) VALUES (?, ?, ?)
+			ON CONFLICT (status_id) DO UPDATE SET reason = excluded.reason, created = excluded.created
+		`), action.Target, action.Reason, action.Created)
+	case ActionUnhide:
+		result, err = tx.Exec(tx.Rebind("DELETE FROM hidden_status_updates WHERE status_id = ?"), action.Target)
+	case ActionBan:
+		result, err = tx.Exec(tx.Rebind(`
+			INSERT INTO banned_pubkeys (pubkey, reason, created) VALUES (?, ?, ?)
+			ON CONFLICT (pubkey) DO UPDATE SET reason = excluded.reason, created = excluded.created
+		`), action.Target, action.Reason, action.Created)
+	case ActionUnban:
+		result, err = tx.Exec(tx.Rebind("DELETE FROM banned_pubkeys WHERE pubkey = ?"), action.Target)
+	case ActionAllow:
+		result, err = tx.Exec(tx.Rebind(`
+			INSERT INTO allowed_pubkeys (pubkey, reason, created) VALUES (?, ?, ?)
+			ON CONFLICT (pubkey) DO UPDATE SET reason = excluded.reason, created = excluded.created
+		`), action.Target, action.Reason, action.Created)
+	case ActionDisallow:
+		result, err = tx.Exec(tx.Rebind("DELETE FROM allowed_pubkeys WHERE pubkey = ?"), action.Target)
+	default:
+		return fmt.Errorf("unknown moderation action %q", action.Action)
+	}
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	err = tx.QueryRowx(tx.Rebind(`
+		INSERT INTO moderation_log (created, actor, action, target, reason) VALUES (?, ?, ?, ?, ?) RETURNING id
+	`), action.Created, action.Actor, action.Action, action.Target, action.Reason).Scan(&action.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) BodyPostedByOthers(body, pubkey string, since int64) (bool, error) {
+	var posted bool
+	err := s.reader.Get(&posted, s.reader.Rebind(`
+		SELECT EXISTS(SELECT 1 FROM status_updates WHERE body = ? AND pubkey <> ? AND timestamp >= ?)
+	`), body, pubkey, since)
+	return posted, err
+}
+
+func (s *sqlStore) GetPubkeyHistory(pubkey string) (PubkeyHistory, error) {
+	var history PubkeyHistory
+	err := s.reader.Get(&history, s.reader.Rebind(`
+		SELECT COUNT(*) AS posts, COALESCE(MIN(timestamp), 0) AS first_post
+		FROM status_updates WHERE pubkey = ? AND `+visibleCondition), pubkey)
+	return history, err
+}
+
+func (s *sqlStore) IsPubkeyBanned(pubkey string) (bool, error) {
+	var banned bool
+	err := s.reader.Get(&banned, s.reader.Rebind("SELECT EXISTS(SELECT 1 FROM banned_pubkeys WHERE pubkey = ?)"), pubkey)
+	return banned, err
+}
+
+func (s *sqlStore) GetBans() ([]Ban, error) {
+	bans := []Ban{}
+	err := s.reader.Select(&bans, "SELECT * FROM banned_pubkeys ORDER BY created DESC, pubkey")
+	return bans, err
+}
+
+func (s *sqlStore) IsPubkeyAllowed(pubkey string) (bool, error) {
+	var allowed bool
+	err := s.reader.Get(&allowed, s.reader.Rebind("SELECT EXISTS(SELECT 1 FROM allowed_pubkeys WHERE pubkey = ?)"), pubkey)
+	return allowed, err
+}
+
+func (s *sqlStore) GetAllowedPubkeys() ([]AllowedPubkey, error) {
+	allowed := []AllowedPubkey{}
+	err := s.reader.Select(&allowed, "SELECT * FROM allowed_pubkeys ORDER BY created DESC, pubkey")
+	return allowed, err
+}
+
+// GetModerationLog returns the newest limit moderation actions, newest
+// first.
+func (s *sqlStore) GetModerationLog(limit int) ([]ModerationAction, error) {
+	actions := []ModerationAction{}
+	err := s.reader.Select(&actions, s.reader.Rebind("SELECT * FROM moderation_log ORDER BY id DESC LIMIT ?"), limit)
+	return actions, err
+}
+
+func (s *sqlStore) AddRejection(rejection *Rejection) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rejection.Created = time.Now().Unix()
+	err = tx.QueryRowx(tx.Rebind(`
+		INSERT INTO rejections (created, pubkey, code, message, remote_addr) VALUES (?, ?, ?, ?, ?) RETURNING id
+	`), rejection.Created, rejection.Pubkey, rejection.Code, rejection.Message, rejection.RemoteAddr).Scan(&rejection.ID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(tx.Rebind("DELETE FROM rejections WHERE id <= ?"), rejection.ID-RejectionsKept); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) AddMessage(msg *Message) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	msg.Created = time.Now().Unix()
+	if _, err := tx.Exec(tx.Rebind("DELETE FROM messages WHERE created < ?"), msg.Created-int64(MessageTTL/time.Second)); err != nil {
+		return err
+	}
+
+	var count int
+	if err := tx.Get(&count, tx.Rebind("SELECT COUNT(*) FROM messages WHERE recipient = ?"), msg.Recipient); err != nil {
+		return err
+	}
+	if count >= MailboxMaxMessages {
+		return ErrMailboxFull
+	}
+	if msg.SenderHash != "" {
+		err := tx.Get(&count, tx.Rebind("SELECT COUNT(*) FROM messages WHERE recipient = ? AND sender_hash = ?"), msg.Recipient, msg.SenderHash)
+		if err != nil {
+			return err
+		}
+		if count >= MailboxMaxPerSender {
+			return ErrTooManyFromSender
+		}
+	}
+
+	err = tx.QueryRowx(tx.Rebind(`
+		INSERT INTO messages (recipient, ciphertext, created, sender_hash) VALUES (?, ?, ?, ?) RETURNING id
+	`), msg.Recipient, msg.Ciphertext, msg.Created, msg.SenderHash).Scan(&msg.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) GetMessages(recipient string, since, limit int) ([]Message, error) {
+	messages := []Message{}
+	err := s.reader.Select(&messages, s.reader.Rebind(`
+		SELECT * FROM messages WHERE recipient = ? AND id > ? ORDER BY id LIMIT ?
+	`), recipient, since, limit)
+	return messages, err
+}
+
+func (s *sqlStore) DeleteMessage(recipient string, id int) (bool, error) {
+	result, err := s.db.Exec(s.db.Rebind("DELETE FROM messages WHERE recipient = ? AND id = ?"), recipient, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (s *sqlStore) AddMedia(media *Media) error {
+	media.Created = time.Now().Unix()
+	_, err := s.db.Exec(s.db.Rebind(`
+		INSERT INTO media (hash, mime_type, size, width, height, thumbnail, pubkey, created)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (hash) DO NOTHING
+	`), media.Hash, media.MimeType, media.Size, media.Width, media.Height, media.Thumbnail, media.Pubkey, media.Created)
+	return err
+}
+
+func (s *sqlStore) GetMedia(hash string) (Media, error) {
+	var media Media
+	err := s.reader.Get(&media, s.reader.Rebind("SELECT * FROM media WHERE hash = ?"), hash)
+	return media, err
+}
+
+func (s *sqlStore) MediaUsage(pubkey string) (int64, error) {
+	var usage int64
+	err := s.reader.Get(&usage, s.reader.Rebind("SELECT COALESCE(SUM(size), 0) FROM media WHERE pubkey = ?"), pubkey)
+	return usage, err
+}
+
+// unattachedMedia matches media rows no post, quarantined or not, attaches.
+const unattachedMedia = `
+	NOT EXISTS (SELECT 1 FROM status_updates WHERE attachments LIKE '%' || media.hash || '%')
+	AND NOT EXISTS (SELECT 1 FROM quarantined_updates WHERE attachments LIKE '%' || media.hash || '%')
+`
+
+func (s *sqlStore) DeleteUnattachedMedia(before int64, limit int) ([]Media, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var candidates []Media
+	err = tx.Select(&candidates, tx.Rebind(`SELECT * FROM media WHERE created < ? AND `+unattachedMedia+` ORDER BY created LIMIT ?`), before, limit)
+	if err != nil {
+		return nil, err
+	}
+	deleted := []Media{}
+	for _, media := range candidates {
+		// A post may have attached it since it was selected.
+		result, err := tx.Exec(tx.Rebind(`DELETE FROM media WHERE hash = ? AND `+unattachedMedia), media.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return nil, err
+		} else if n > 0 {
+			deleted = append(deleted, media)
+		}
+	}
+	return deleted, tx.Commit()
+}
+
+// GetRejections returns the newest limit rejections, newest first.
+func (s *sqlStore) GetRejections(limit int) ([]Rejection, error) {
+	rejections := []Rejection{}
+	err := s.reader.Select(&rejections, s.reader.Rebind("SELECT * FROM rejections ORDER BY id DESC LIMIT ?"), limit)
+	return rejections, err
+}
@@ -0,0 +1,21 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+type postgresStore struct {
+	*sqlStore
+}
+
+func newPostgresStore(url string) (*postgresStore, error) {
+	conn, err := sqlx.Connect("postgres", url)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to database: %v", err)
+	}
+
+	return &postgresStore{newSQLStore(conn, conn, "postgres")}, nil
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	// sqliteBusyTimeout is how long, in milliseconds, a connection waits
+	// for a lock held by another connection or process before giving up
+	// with "database is locked".
+	sqliteBusyTimeout = 5000
+	// sqliteReaderConns caps the read pool. WAL lets readers run alongside
+	// the writer, so this only bounds open file handles.
+	sqliteReaderConns = 8
+)
+
+type sqliteStore struct {
+	*sqlStore
+	// path is the database file, empty for memory stores.
+	path string
+}
+
+// OpenMemoryStore returns a migrated SQLite store that lives only in memory,
+// for tests and throwaway instances.
+func OpenMemoryStore() (Store, error) {
+	conn, err := sqlx.Connect("sqlite3", ":memory:")
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to database: %v", err)
+	}
+	// Every connection to :memory: opens a separate database, so the pool
+	// has to stick to one that is never closed, and reads share it.
+	conn.SetMaxOpenConns(1)
+	conn.SetConnMaxLifetime(0)
+	conn.SetConnMaxIdleTime(0)
+
+	s := &sqliteStore{sqlStore: newSQLStore(conn, conn, "sqlite")}
+	if _, err := s.Migrate(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// newSQLiteStore opens path in WAL mode with one writer connection and a
+// pool of read-only connections. SQLite only ever allows one writer, so
+// funnelling writes through a single connection makes them queue in Go
+// instead of failing with "database is locked", while WAL keeps the feed
+// readable during writes.
+func newSQLiteStore(path string) (*sqliteStore, error) {
+	writer, err := sqlx.Connect("sqlite3", sqliteDSN(path)+"&_txlock=immediate")
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to database: %v", err)
+	}
+	writer.SetMaxOpenConns(1)
+	writer.SetConnMaxLifetime(0)
+	writer.SetConnMaxIdleTime(0)
+
+	reader, err := sqlx.Connect("sqlite3", sqliteDSN(path)+"&_query_only=true")
+	if err != nil {
+		writer.Close()
+		return nil, fmt.Errorf("error connecting to database: %v", err)
+	}
+	reader.SetMaxOpenConns(sqliteReaderConns)
+	reader.SetMaxIdleConns(sqliteReaderConns)
+
+	return &sqliteStore{sqlStore: newSQLStore(writer, reader, "sqlite"), path: path}, nil
+}
+
+func sqliteDSN(path string) string {
+	return fmt.Sprintf("%s?_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=%d", path, sqliteBusyTimeout)
+}
+
+// Backup writes a consistent copy of the database to path with VACUUM INTO,
+// which is safe while the server is running. It uses a connection of its
+// own so that posts are not held up behind a long backup.
+func (s *sqliteStore) Backup(path string) error {
+	conn := s.db
+	if s.path != "" {
+		c, err := sqlx.Connect("sqlite3", sqliteDSN(s.path))
+		if err != nil {
+			return fmt.Errorf("error connecting to database: %v", err)
+		}
+		defer c.Close()
+		conn = c
+	}
+
+	_, err := conn.Exec("VACUUM INTO ?", path)
+	return err
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+const (
+	// StatusUpdateRequestMaxSize bounds a POST /status body: a body and
+	// link with every byte escaped as \uXXXX, the hex pubkey and signature,
+	// and room for field names and whitespace. Anything bigger cannot be a
+	// valid post, so reading stops there.
+	StatusUpdateRequestMaxSize = 6*(BodyMaxSize+LinkMaxSize) + 2*(PubkeyMaxSize+SignatureMaxSize) + NonceMaxSize + 1024
+	// WebhookRequestMaxSize bounds a POST /webhooks body.
+	WebhookRequestMaxSize = 8 * 1024
+)
+
+// decodeJSON reads a request body holding exactly one JSON object into v.
+// Bodies that are not JSON, larger than maxSize, contain fields v does not
+// have, or carry anything after the object are answered with an error and
+// false is returned.
+func (s *Server) decodeJSON(w http.ResponseWriter, r *http.Request, v any, maxSize int64) bool {
+	if !s.requireJSON(w, r) {
+		return false
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSize))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(v)
+	if err == nil {
+		// A second value, or garbage, after the object.
+		if dec.Decode(&struct{}{}) != io.EOF {
+			s.handleError(w, r, CodeInvalidPayload, "Request body must contain a single JSON object", http.StatusBadRequest)
+			return false
+		}
+		return true
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		s.handleError(w, r, CodePayloadTooLarge, "Request body is too large", http.StatusRequestEntityTooLarge)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		s.writeError(w, r, ErrorResponse{Code: CodeInvalidPayload, Message: "Unknown field " + field, Field: field}, http.StatusBadRequest)
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		s.writeError(w, r, ErrorResponse{
+			Code:    CodeInvalidPayload,
+			Message: typeErr.Field + " must be a JSON " + jsonTypeName(typeErr.Type.Kind()),
+			Field:   typeErr.Field,
+		}, http.StatusBadRequest)
+	default:
+		s.handleError(w, r, CodeInvalidPayload, "Invalid request payload", http.StatusBadRequest)
+	}
+	return false
+}
+
+func jsonTypeName(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	}
+	if kind >= reflect.Int && kind <= reflect.Float64 {
+		return "number"
+	}
+	return "value"
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/donuts-are-good/postshortly/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestCreateStatusUpdateStrictDecoding(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	srv.limiter = rate.NewLimiter(rate.Inf, 1)
+
+	_, privkey, _ := sdk.GenerateKey()
+	update := sdk.StatusUpdate{Body: "Test body"}
+	sdk.SignStatusUpdate(privkey, &update)
+	valid, _ := json.Marshal(update)
+	object := strings.TrimSuffix(string(valid), "}")
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+		field  string
+	}{
+		{"unknown field", object + `,"extra":1}`, http.StatusBadRequest, CodeInvalidPayload, "extra"},
+		{"wrong type", object + `,"link":5}`, http.StatusBadRequest, CodeInvalidPayload, "link"},
+		{"two objects", string(valid) + string(valid), http.StatusBadRequest, CodeInvalidPayload, ""},
+		{"trailing garbage", string(valid) + "garbage", http.StatusBadRequest, CodeInvalidPayload, ""},
+		{"array", "[" + string(valid) + "]", http.StatusBadRequest, CodeInvalidPayload, ""},
+		{"truncated", string(valid[:len(valid)-1]), http.StatusBadRequest, CodeInvalidPayload, ""},
+		{"too large", object + `,"link":"` + strings.Repeat("a", StatusUpdateRequestMaxSize) + `"}`, http.StatusRequestEntityTooLarge, CodePayloadTooLarge, ""},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "/status", strings.NewReader(tt.body))
+		rr := httptest.NewRecorder()
+		srv.setupRouter().ServeHTTP(rr, req)
+		assert.Equal(t, tt.status, rr.Code, tt.name)
+
+		var resp ErrorResponse
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp), tt.name)
+		assert.Equal(t, tt.code, resp.Code, tt.name)
+		assert.Equal(t, tt.field, resp.Field, tt.name)
+	}
+
+	// Trailing whitespace is not a second value, and a client cannot claim
+	// a post came from another instance.
+	withOrigin := object + `,"origin":"https://elsewhere.example"}` + "\n\n"
+	req, _ := http.NewRequest("POST", "/status", strings.NewReader(withOrigin))
+	rr := httptest.NewRecorder()
+	srv.setupRouter().ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var created StatusUpdate
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&created))
+	assert.Empty(t, created.Origin)
+}
+
+func TestStatusUpdateRequestMaxSizeFitsLargestPost(t *testing.T) {
+	// The largest valid post must still be read in full when every
+	// character is escaped; encoding/json writes < as \u003c.
+	update := StatusUpdate{
+		ID:        1 << 40,
+		Timestamp: 1 << 62,
+		Body:      strings.Repeat("<", BodyMaxSize),
+		Link:      strings.Repeat("<", LinkMaxSize),
+		Pubkey:    strings.Repeat("a", PubkeyMaxSize*2),
+		Signature: strings.Repeat("b", SignatureMaxSize*2),
+	}
+	body, err := json.MarshalIndent(update, "", "    ")
+	require.NoError(t, err)
+	assert.Less(t, len(body), StatusUpdateRequestMaxSize)
+}
+
+func TestCreateWebhookStrictDecoding(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	srv.config.AdminToken = testAdminToken
+
+	rr := httptest.NewRecorder()
+	srv.setupRouter().ServeHTTP(rr, adminRequest("POST", "/webhooks", strings.NewReader(`{"url":"https://bot.example.com","events":["all"]}`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	srv.setupRouter().ServeHTTP(rr, adminRequest("POST", "/webhooks", strings.NewReader(`{"url":"https://`+strings.Repeat("a", WebhookRequestMaxSize)+`"}`)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+}
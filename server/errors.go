@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// Error codes sent in the "code" field of error responses. Clients match on
+// them instead of the message, so they must never change once released.
+const (
+	CodeInvalidPayload       = "invalid_payload"
+	CodeBodyRequired         = "body_required"
+	CodeBodyTooLong          = "body_too_long"
+	CodeLinkTooLong          = "link_too_long"
+	CodeInvalidPubkey        = "invalid_pubkey"
+	CodeInvalidSignature     = "invalid_signature"
+	CodeSignatureMismatch    = "signature_mismatch"
+	CodeInsufficientWork     = "insufficient_proof_of_work"
+	CodeBlockedContent       = "blocked_content"
+	CodeBlockedDomain        = "blocked_domain"
+	CodeTooManyLinks         = "too_many_links"
+	CodeRepeatedCharacters   = "repeated_characters"
+	CodeDuplicateBody        = "duplicate_body"
+	CodeMailboxFull          = "mailbox_full"
+	CodeInvalidAttachment    = "invalid_attachment"
+	CodeUnknownAttachment    = "unknown_attachment"
+	CodeMediaTypeNotAllowed  = "media_type_not_allowed"
+	CodeImageTooLarge        = "image_too_large"
+	CodeMediaDisabled        = "media_disabled"
+	CodeMediaQuotaExceeded   = "media_quota_exceeded"
+	CodeInvalidParameter     = "invalid_parameter"
+	CodeIdempotencyKeyReused = "idempotency_key_reused"
+	CodePubkeyBanned         = "pubkey_banned"
+	CodePubkeyDenied         = "pubkey_denied"
+	CodePubkeyNotAllowed     = "pubkey_not_allowed"
+	CodeRateLimited          = "rate_limited"
+	CodeUnauthorized         = "unauthorized"
+	CodeAdminDisabled        = "admin_disabled"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeNotAcceptable        = "not_acceptable"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodePayloadTooLarge      = "payload_too_large"
+	CodeNotImplemented       = "not_implemented"
+	CodeInternal             = "internal_error"
+)
+
+// RequestIDHeader carries the id of a request, taken from the client when
+// it sends one and generated otherwise. It is echoed in every response and
+// in error bodies so a failure can be found in the server log.
+const RequestIDHeader = "X-Request-ID"
+
+// ErrorResponse is the JSON body of every error response.
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Field names the request field at fault, if any.
+	Field     string `json:"field,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// ValidationError reports a request field that failed validation.
+type ValidationError struct {
+	Code    string
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func (s *Server) handleError(w http.ResponseWriter, r *http.Request, code, message string, statusCode int) {
+	s.writeError(w, r, ErrorResponse{Code: code, Message: message}, statusCode)
+}
+
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, resp ErrorResponse, statusCode int) {
+	s.metrics.failedRequests.Add(1)
+	resp.RequestID = requestID(r)
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(resp)
+
+	level := slog.LevelDebug
+	if statusCode >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	s.log.LogAttrs(r.Context(), level, "request failed",
+		slog.String("code", resp.Code),
+		slog.String("message", resp.Message),
+		slog.Int("status", statusCode),
+	)
+}
+
+type requestIDKey struct{}
+
+// requestIDMiddleware gives every request an id, reusing a sane one sent by
+// the client.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the id requestIDMiddleware gave r, or "" for requests
+// that did not pass through it.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/donuts-are-good/postshortly/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestValidationErrorCodes(t *testing.T) {
+	_, privkey, _ := sdk.GenerateKey()
+	signed := func(body, link string) StatusUpdate {
+		update := sdk.StatusUpdate{Body: body, Link: link}
+		sdk.SignStatusUpdate(privkey, &update)
+		return fromSDKStatusUpdate(update)
+	}
+
+	tests := []struct {
+		name   string
+		update StatusUpdate
+		code   string
+		field  string
+	}{
+		{"empty body", signed("", ""), CodeBodyRequired, "body"},
+		{"long body", signed(strings.Repeat("a", BodyMaxSize+1), ""), CodeBodyTooLong, "body"},
+		{"long link", signed("body", strings.Repeat("a", LinkMaxSize+1)), CodeLinkTooLong, "link"},
+		{"short pubkey", func() StatusUpdate { u := signed("body", ""); u.Pubkey = "abc"; return u }(), CodeInvalidPubkey, "pubkey"},
+		{"hex pubkey", func() StatusUpdate {
+			u := signed("body", "")
+			u.Pubkey = strings.Repeat("z", PubkeyMaxSize*2)
+			return u
+		}(), CodeInvalidPubkey, "pubkey"},
+		{"short signature", func() StatusUpdate { u := signed("body", ""); u.Signature = "abc"; return u }(), CodeInvalidSignature, "signature"},
+		{"hex signature", func() StatusUpdate {
+			u := signed("body", "")
+			u.Signature = strings.Repeat("z", SignatureMaxSize*2)
+			return u
+		}(), CodeInvalidSignature, "signature"},
+		{"forged", func() StatusUpdate { u := signed("body", ""); u.Body = "forged"; return u }(), CodeSignatureMismatch, "signature"},
+	}
+	for _, tt := range tests {
+		err := validateStatusUpdate(tt.update, 0)
+		var verr *ValidationError
+		if assert.ErrorAs(t, err, &verr, tt.name) {
+			assert.Equal(t, tt.code, verr.Code, tt.name)
+			assert.Equal(t, tt.field, verr.Field, tt.name)
+		}
+	}
+}
+
+func TestErrorResponseEnvelope(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	srv.limiter = rate.NewLimiter(rate.Inf, 1)
+	handler := srv.Handler()
+
+	decode := func(rr *httptest.ResponseRecorder) ErrorResponse {
+		assert.Equal(t, contentTypeJSON, rr.Header().Get("Content-Type"))
+		var resp ErrorResponse
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		return resp
+	}
+
+	update := StatusUpdate{Body: strings.Repeat("a", BodyMaxSize+1)}
+	body, _ := json.Marshal(update)
+	req, _ := http.NewRequest("POST", "/status", bytes.NewReader(body))
+	req.Header.Set(RequestIDHeader, "client-chosen-id")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "client-chosen-id", rr.Header().Get(RequestIDHeader))
+	assert.Equal(t, ErrorResponse{
+		Code:      CodeBodyTooLong,
+		Message:   "body exceeds maximum size of 256 characters",
+		Field:     "body",
+		RequestID: "client-chosen-id",
+	}, decode(rr))
+
+	// Unusable client ids are replaced rather than echoed.
+	req, _ = http.NewRequest("GET", "/nowhere", nil)
+	req.Header.Set(RequestIDHeader, "bad id\n")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	resp := decode(rr)
+	assert.Equal(t, CodeNotFound, resp.Code)
+	assert.Len(t, resp.RequestID, 16)
+	assert.Equal(t, resp.RequestID, rr.Header().Get(RequestIDHeader))
+
+	req, _ = http.NewRequest("DELETE", "/status", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	assert.Equal(t, CodeMethodNotAllowed, decode(rr).Code)
+}
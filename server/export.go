@@ -0,0 +1,159 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/donuts-are-good/postshortly/archive"
+	"github.com/donuts-are-good/postshortly/sdk"
+)
+
+type ImportRejection struct {
+	Pubkey    string `json:"pubkey"`
+	Signature string `json:"signature"`
+	Reason    string `json:"reason"`
+}
+
+type ImportReport struct {
+	Imported   int               `json:"imported"`
+	Duplicates int               `json:"duplicates"`
+	Rejected   []ImportRejection `json:"rejected"`
+}
+
+func (s *Server) exportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"postshortly-%d.ndjson\"", time.Now().Unix()))
+
+	// Headers are already sent once streaming starts, so a failure midway
+	// can only be reported by the missing manifest.
+	if err := exportArchive(s.forEachVisibleStatusUpdate, w); err != nil {
+		s.metrics.failedRequests.Add(1)
+		s.log.ErrorContext(r.Context(), "error exporting archive", "error", err)
+		return
+	}
+	s.metrics.successfulRequests.Add(1)
+}
+
+// ExportArchive streams every stored post to w in archive format,
+// including posts hidden by moderation.
+func ExportArchive(store Store, w io.Writer) error {
+	return exportArchive(store.ForEachStatusUpdate, w)
+}
+
+func exportArchive(each func(fn func(StatusUpdate) error) error, w io.Writer) error {
+	aw, err := archive.NewWriter(w)
+	if err != nil {
+		return err
+	}
+
+	err = each(func(update StatusUpdate) error {
+		return aw.Write(toSDKStatusUpdate(update))
+	})
+	if err != nil {
+		return err
+	}
+
+	return aw.Close()
+}
+
+// ImportArchive reads an archive and stores its posts. The whole archive is
+// read and its manifest checked before anything is inserted; each post is
+// then validated and checked like a POST /status request and skipped if a
+// post with the same pubkey and signature already exists.
+func (s *Server) ImportArchive(r io.Reader) (ImportReport, error) {
+	report := ImportReport{Rejected: []ImportRejection{}}
+
+	ar, err := archive.NewReader(r)
+	if err != nil {
+		return report, err
+	}
+	posts, err := ar.ReadAll()
+	if err != nil {
+		return report, err
+	}
+
+	for _, post := range posts {
+		update := fromSDKStatusUpdate(post)
+		canonicalizeStatusUpdate(&update)
+		if err := validateStatusUpdate(update, 0); err != nil {
+			report.Rejected = append(report.Rejected, ImportRejection{Pubkey: post.Pubkey, Signature: post.Signature, Reason: err.Error()})
+			continue
+		}
+		if err := s.checkReceivedStatusUpdate(update); err != nil {
+			if _, ok := err.(*ValidationError); !ok {
+				return report, err
+			}
+			report.Rejected = append(report.Rejected, ImportRejection{Pubkey: post.Pubkey, Signature: post.Signature, Reason: err.Error()})
+			continue
+		}
+
+		if update.Timestamp == 0 {
+			update.Timestamp = time.Now().UnixNano()
+		}
+		err := s.store.AddStatusUpdate(&update)
+		if errors.Is(err, ErrDuplicateStatusUpdate) {
+			report.Duplicates++
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		report.Imported++
+	}
+
+	return report, nil
+}
+
+func toSDKStatusUpdate(update StatusUpdate) sdk.StatusUpdate {
+	return sdk.StatusUpdate{
+		ID:        update.ID,
+		Timestamp: update.Timestamp,
+		Body:      update.Body,
+		Link:      update.Link,
+		Pubkey:    update.Pubkey,
+		Signature: update.Signature,
+		Origin:    update.Origin,
+		Nonce:     update.Nonce,
+
+		Attachments: update.Attachments,
+	}
+}
+
+// fromSDKStatusUpdate converts a post received from elsewhere. The ID is
+// dropped since it only has meaning on the instance that assigned it.
+func fromSDKStatusUpdate(update sdk.StatusUpdate) StatusUpdate {
+	return StatusUpdate{
+		Timestamp: update.Timestamp,
+		Body:      update.Body,
+		Link:      update.Link,
+		Pubkey:    update.Pubkey,
+		Signature: update.Signature,
+		Origin:    update.Origin,
+
+		Attachments: update.Attachments,
+	}
+}
+
+// forEachVisibleStatusUpdate calls fn for every post the instance serves,
+// in id order, a page at a time.
+func (s *Server) forEachVisibleStatusUpdate(fn func(StatusUpdate) error) error {
+	since := 0
+	for {
+		updates, err := s.store.GetStatusUpdatesSince(since, FeedMaxPageSize)
+		if err != nil {
+			return err
+		}
+		for _, update := range updates {
+			if err := fn(update); err != nil {
+				return err
+			}
+			since = update.ID
+		}
+		if len(updates) < FeedMaxPageSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/donuts-are-good/postshortly/archive"
+	"github.com/donuts-are-good/postshortly/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImport(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+
+	_, privkey, _ := sdk.GenerateKey()
+	for _, body := range []string{"first", "second"} {
+		signed := sdk.StatusUpdate{Body: body}
+		sdk.SignStatusUpdate(privkey, &signed)
+		update := fromSDKStatusUpdate(signed)
+		update.Timestamp = time.Now().UnixNano()
+		assert.NoError(t, srv.store.AddStatusUpdate(&update))
+	}
+
+	req, err := http.NewRequest("GET", "/export", nil)
+	assert.NoError(t, err)
+	rr := httptest.NewRecorder()
+	srv.setupRouter().ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/x-ndjson", rr.Header().Get("Content-Type"))
+	exported := rr.Body.Bytes()
+
+	ar, err := archive.NewReader(bytes.NewReader(exported))
+	assert.NoError(t, err)
+	posts, err := ar.ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, posts, 2)
+
+	// Importing into the instance that produced the archive only finds
+	// duplicates.
+	report, err := srv.ImportArchive(bytes.NewReader(exported))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Imported)
+	assert.Equal(t, 2, report.Duplicates)
+
+	other := newTestServer(t)
+	report, err = other.ImportArchive(bytes.NewReader(exported))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Imported)
+	assert.Empty(t, report.Rejected)
+
+	updates, err := other.store.GetAllStatusUpdates()
+	assert.NoError(t, err)
+	assert.Len(t, updates, 2)
+	assert.Equal(t, posts[1].Timestamp, updates[0].Timestamp)
+}
+
+func TestImportRejectsBadSignatures(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+
+	_, privkey, _ := sdk.GenerateKey()
+	good := sdk.StatusUpdate{Timestamp: time.Now().UnixNano(), Body: "good"}
+	sdk.SignStatusUpdate(privkey, &good)
+	forged := good
+	forged.Body = "forged"
+
+	var buf bytes.Buffer
+	aw, _ := archive.NewWriter(&buf)
+	aw.Write(good)
+	aw.Write(forged)
+	aw.Close()
+
+	report, err := srv.ImportArchive(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Imported)
+	assert.Len(t, report.Rejected, 1)
+	assert.Contains(t, report.Rejected[0].Reason, "signature verification failed")
+}
+
+func TestImportDamagedArchiveInsertsNothing(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+
+	_, privkey, _ := sdk.GenerateKey()
+	post := sdk.StatusUpdate{Timestamp: time.Now().UnixNano(), Body: "good"}
+	sdk.SignStatusUpdate(privkey, &post)
+
+	var buf bytes.Buffer
+	aw, _ := archive.NewWriter(&buf)
+	aw.Write(post)
+	aw.Close()
+	truncated := strings.Join(strings.SplitAfter(buf.String(), "\n")[:2], "")
+
+	_, err := srv.ImportArchive(strings.NewReader(truncated))
+	assert.ErrorIs(t, err, archive.ErrTruncate)
+
+	updates, err := srv.store.GetAllStatusUpdates()
+	assert.NoError(t, err)
+	assert.Empty(t, updates)
+}
+
+func TestImportChecksBans(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+
+	pub, banned, _ := sdk.GenerateKey()
+	require.NoError(t, srv.store.Moderate(&ModerationAction{Actor: "token", Action: ActionBan, Target: hex.EncodeToString(pub)}))
+	_, other, _ := sdk.GenerateKey()
+
+	var buf bytes.Buffer
+	aw, _ := archive.NewWriter(&buf)
+	for _, post := range []struct {
+		key ed25519.PrivateKey
+		sdk.StatusUpdate
+	}{
+		{banned, sdk.StatusUpdate{Body: "still here"}},
+		{other, sdk.StatusUpdate{Body: "look", Attachments: []string{strings.Repeat("ab", 32)}}},
+		{other, sdk.StatusUpdate{Body: "fine"}},
+	} {
+		sdk.SignStatusUpdate(post.key, &post.StatusUpdate)
+		aw.Write(post.StatusUpdate)
+	}
+	aw.Close()
+
+	report, err := srv.ImportArchive(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Imported)
+	require.Len(t, report.Rejected, 2)
+	assert.Equal(t, "pubkey is banned", report.Rejected[0].Reason)
+	assert.Contains(t, report.Rejected[1].Reason, "was not uploaded")
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FilterConfig sets the content policy for new posts. Every rule is off
+// when left at its zero value.
+type FilterConfig struct {
+	// BlockedPatterns are regular expressions; posts whose body or link
+	// match one are refused.
+	BlockedPatterns []string `json:"blocked_patterns"`
+	// BlockedWords are refused as whole words, ignoring case.
+	BlockedWords []string `json:"blocked_words"`
+	// BlockedDomains refuses links, in the body or the link field, to
+	// these domains and their subdomains.
+	BlockedDomains []string `json:"blocked_domains"`
+	// MaxLinks caps the number of links in a post, counting the link
+	// field.
+	MaxLinks int `json:"max_links"`
+	// MaxRepeatedChars caps runs of one character, as in "!!!!!!!!".
+	MaxRepeatedChars int `json:"max_repeated_chars"`
+	// DuplicateWindow refuses a body another pubkey posted within this
+	// long.
+	DuplicateWindow Duration `json:"duplicate_window"`
+}
+
+// ContentFilter decides whether a post may be published. Check returns a
+// *ValidationError saying why a post is refused, nil to let it through, or
+// any other error when it could not decide.
+type ContentFilter interface {
+	Check(update StatusUpdate) error
+}
+
+// ContentFilterFunc adapts a function to ContentFilter.
+type ContentFilterFunc func(update StatusUpdate) error
+
+func (f ContentFilterFunc) Check(update StatusUpdate) error {
+	return f(update)
+}
+
+// AddContentFilter appends a filter to the chain run on every POST /status
+// after the built-in ones. It must be called before the server handles
+// requests.
+func (s *Server) AddContentFilter(f ContentFilter) {
+	s.filters = append(s.filters, f)
+}
+
+// checkContent runs the filter chain, stopping at the first refusal.
+func (s *Server) checkContent(update StatusUpdate) error {
+	for _, f := range s.filters {
+		if err := f.Check(update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compile checks the config, returning the filters it describes.
+func (cfg FilterConfig) compile(store Store) ([]ContentFilter, error) {
+	var filters []ContentFilter
+	for _, pattern := range cfg.BlockedPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("bad blocked pattern %q: %v", pattern, err)
+		}
+		filters = append(filters, patternFilter{re})
+	}
+	if len(cfg.BlockedWords) > 0 {
+		words := make([]string, len(cfg.BlockedWords))
+		for i, word := range cfg.BlockedWords {
+			words[i] = regexp.QuoteMeta(word)
+		}
+		// \b only works next to word characters, so words such as "c++"
+		// are bounded by anything that isn't a letter or digit instead.
+		filters = append(filters, patternFilter{regexp.MustCompile(`(?i)(?:^|[^\pL\pN_])(?:` + strings.Join(words, "|") + `)(?:$|[^\pL\pN_])`)})
+	}
+	if len(cfg.BlockedDomains) > 0 {
+		filters = append(filters, domainFilter(cfg.BlockedDomains))
+	}
+	if cfg.MaxLinks > 0 {
+		filters = append(filters, linkCountFilter(cfg.MaxLinks))
+	}
+	if cfg.MaxRepeatedChars > 0 {
+		filters = append(filters, repeatFilter(cfg.MaxRepeatedChars))
+	}
+	if cfg.DuplicateWindow > 0 {
+		filters = append(filters, duplicateFilter{store, time.Duration(cfg.DuplicateWindow)})
+	}
+	return filters, nil
+}
+
+type patternFilter struct {
+	re *regexp.Regexp
+}
+
+func (f patternFilter) Check(update StatusUpdate) error {
+	if f.re.MatchString(update.Body) || f.re.MatchString(update.Link) {
+		return &ValidationError{Code: CodeBlockedContent, Field: "body", Message: "post contains blocked content"}
+	}
+	return nil
+}
+
+// urlPattern finds links written out in a post body.
+var urlPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"']+`)
+
+// postLinks returns the links in the body and the link field.
+func postLinks(update StatusUpdate) []string {
+	links := urlPattern.FindAllString(update.Body, -1)
+	if update.Link != "" {
+		links = append(links, update.Link)
+	}
+	return links
+}
+
+// linkHost returns the lower case host name a link points to.
+func linkHost(link string) string {
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+}
+
+type domainFilter []string
+
+func (f domainFilter) Check(update StatusUpdate) error {
+	for _, link := range postLinks(update) {
+		host := linkHost(link)
+		for _, domain := range f {
+			domain = strings.ToLower(domain)
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return &ValidationError{Code: CodeBlockedDomain, Field: "link", Message: fmt.Sprintf("links to %s are not allowed", domain)}
+			}
+		}
+	}
+	return nil
+}
+
+type linkCountFilter int
+
+func (f linkCountFilter) Check(update StatusUpdate) error {
+	if len(postLinks(update)) > int(f) {
+		return &ValidationError{Code: CodeTooManyLinks, Field: "body", Message: fmt.Sprintf("post has more than %d links", int(f))}
+	}
+	return nil
+}
+
+type repeatFilter int
+
+func (f repeatFilter) Check(update StatusUpdate) error {
+	var last rune = utf8.RuneError
+	run := 0
+	for _, r := range update.Body {
+		if r == last {
+			run++
+		} else {
+			last, run = r, 1
+		}
+		if run > int(f) {
+			return &ValidationError{Code: CodeRepeatedCharacters, Field: "body", Message: fmt.Sprintf("body repeats a character more than %d times", int(f))}
+		}
+	}
+	return nil
+}
+
+type duplicateFilter struct {
+	store  Store
+	window time.Duration
+}
+
+func (f duplicateFilter) Check(update StatusUpdate) error {
+	since := time.Now().Add(-f.window).UnixNano()
+	duplicate, err := f.store.BodyPostedByOthers(update.Body, update.Pubkey, since)
+	if err != nil {
+		return fmt.Errorf("error checking for duplicate posts: %v", err)
+	}
+	if duplicate {
+		return &ValidationError{Code: CodeDuplicateBody, Field: "body", Message: "the same body was recently posted by another pubkey"}
+	}
+	return nil
+}
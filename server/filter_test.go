@@ -0,0 +1,124 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/donuts-are-good/postshortly/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestContentFilters(t *testing.T) {
+	t.Parallel()
+	filters, err := FilterConfig{
+		BlockedPatterns:  []string{`(?i)free\s+crypto`},
+		BlockedWords:     []string{"spam", "c++"},
+		BlockedDomains:   []string{"bad.example"},
+		MaxLinks:         2,
+		MaxRepeatedChars: 5,
+	}.compile(nil)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		body string
+		link string
+		code string
+	}{
+		{"clean", "hello there", "https://good.example", ""},
+		{"pattern", "get FREE   crypto now", "", CodeBlockedContent},
+		{"pattern in link", "hi", "https://x.example/free crypto", CodeBlockedContent},
+		{"word", "no Spam here", "", CodeBlockedContent},
+		{"word needs boundaries", "spammer", "", ""},
+		{"quoted word", "I like c++ a lot", "", CodeBlockedContent},
+		{"domain in link", "hi", "https://bad.example/x", CodeBlockedDomain},
+		{"subdomain in body", "see www.shop.bad.example", "", CodeBlockedDomain},
+		{"lookalike domain", "see https://notbad.example", "", ""},
+		{"links", "https://a.example https://b.example", "https://c.example", CodeTooManyLinks},
+		{"two links", "https://a.example", "https://c.example", ""},
+		{"repeats", "wow!!!!!!", "", CodeRepeatedCharacters},
+		{"five repeats", "wow!!!!!", "", ""},
+	}
+	for _, tt := range tests {
+		var got string
+		for _, f := range filters {
+			err := f.Check(StatusUpdate{Body: tt.body, Link: tt.link})
+			var verr *ValidationError
+			if errors.As(err, &verr) {
+				got = verr.Code
+				break
+			}
+			require.NoError(t, err, tt.name)
+		}
+		assert.Equal(t, tt.code, got, tt.name)
+	}
+
+	_, err = FilterConfig{BlockedPatterns: []string{"("}}.compile(nil)
+	assert.Error(t, err)
+}
+
+func TestDuplicateBodyFilter(t *testing.T) {
+	t.Parallel()
+	store, err := OpenMemoryStore()
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	cfg := DefaultConfig()
+	cfg.Filters.DuplicateWindow = Duration(time.Hour)
+	srv, err := New(cfg, store)
+	require.NoError(t, err)
+	srv.limiter = rate.NewLimiter(rate.Inf, 1)
+	handler := srv.Handler()
+
+	post := func(body string) *ErrorResponse {
+		t.Helper()
+		_, priv, _ := sdk.GenerateKey()
+		update := sdk.StatusUpdate{Body: body}
+		sdk.SignStatusUpdate(priv, &update)
+		rr := postStatusUpdate(t, handler, update)
+		if rr.Code == http.StatusOK {
+			return nil
+		}
+		var resp ErrorResponse
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		return &resp
+	}
+
+	assert.Nil(t, post("buy my stuff"))
+	resp := post("buy my stuff")
+	require.NotNil(t, resp)
+	assert.Equal(t, CodeDuplicateBody, resp.Code)
+	assert.Nil(t, post("something else"))
+
+	// Custom filters run after the built-in ones.
+	srv.AddContentFilter(ContentFilterFunc(func(update StatusUpdate) error {
+		if strings.Contains(update.Body, "lottery") {
+			return &ValidationError{Code: CodeBlockedContent, Field: "body", Message: "no lotteries"}
+		}
+		return nil
+	}))
+	resp = post("win the lottery")
+	require.NotNil(t, resp)
+	assert.Equal(t, "no lotteries", resp.Message)
+
+	rejections, err := store.GetRejections(10)
+	require.NoError(t, err)
+	require.Len(t, rejections, 2)
+	assert.Equal(t, CodeBlockedContent, rejections[0].Code)
+}
+
+func TestNewRejectsBadFilters(t *testing.T) {
+	t.Parallel()
+	store, err := OpenMemoryStore()
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	cfg := DefaultConfig()
+	cfg.Filters.BlockedPatterns = []string{"("}
+	_, err = New(cfg, store)
+	assert.ErrorContains(t, err, "bad blocked pattern")
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// Version is the release of the build, set with
+// -ldflags "-X github.com/donuts-are-good/postshortly/server.Version=v1.2.3".
+var Version = "dev"
+
+const (
+	// readyPingTimeout bounds the database check of GET /readyz.
+	readyPingTimeout = 2 * time.Second
+)
+
+// ReadinessReport is the body of GET /readyz. Checks maps each check to
+// "ok" or what is wrong.
+type ReadinessReport struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// BuildInfo is the body of GET /version.
+type BuildInfo struct {
+	Version   string        `json:"version"`
+	Commit    string        `json:"commit,omitempty"`
+	BuildTime string        `json:"build_time,omitempty"`
+	Modified  bool          `json:"modified,omitempty"`
+	GoVersion string        `json:"go_version"`
+	Config    ConfigSummary `json:"config"`
+}
+
+// ConfigSummary describes the running configuration without revealing
+// secrets such as the admin token or database credentials.
+type ConfigSummary struct {
+	Database       string   `json:"database"`
+	Peers          int      `json:"peers"`
+	SyncInterval   string   `json:"sync_interval"`
+	AdminEnabled   bool     `json:"admin_enabled"`
+	BackupInterval string   `json:"backup_interval"`
+	AllowedOrigins []string `json:"allowed_origins"`
+	LogLevel       string   `json:"log_level"`
+	AccessMode     string   `json:"access_mode"`
+}
+
+// healthzHandler reports that the process is up and serving requests.
+func (s *Server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	s.render(w, r, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyzHandler answers 200 when the server can do its work: the database
+// answers and its schema is current. Background jobs such as the
+// statistics recorder are left out: a slow run says nothing about whether
+// requests can be served. Otherwise it answers 503 so load balancers stop sending traffic.
+func (s *Server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	report := ReadinessReport{Status: "ready", Checks: map[string]string{
+		"database":   "ok",
+		"migrations": "ok",
+	}}
+	fail := func(check, problem string) {
+		report.Status = "not_ready"
+		report.Checks[check] = problem
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyPingTimeout)
+	defer cancel()
+	if err := s.store.Ping(ctx); err != nil {
+		s.log.ErrorContext(r.Context(), "readiness check failed", "check", "database", "error", err)
+		fail("database", "unreachable")
+	}
+
+	// A schema only ever moves forward, so it is checked until it is
+	// current once.
+	if !s.migrated.Load() {
+		current, latest, err := s.store.SchemaVersion()
+		switch {
+		case err != nil:
+			s.log.ErrorContext(r.Context(), "readiness check failed", "check", "migrations", "error", err)
+			fail("migrations", "unknown schema version")
+		case current < latest:
+			fail("migrations", fmt.Sprintf("schema version %d, latest %d", current, latest))
+		default:
+			s.migrated.Store(true)
+		}
+	}
+
+	statusCode := http.StatusOK
+	if report.Status != "ready" {
+		statusCode = http.StatusServiceUnavailable
+	}
+	s.render(w, r, statusCode, report)
+}
+
+func (s *Server) versionHandler(w http.ResponseWriter, r *http.Request) {
+	s.render(w, r, http.StatusOK, s.buildInfo())
+}
+
+func (s *Server) buildInfo() BuildInfo {
+	info := BuildInfo{
+		Version:   Version,
+		GoVersion: runtime.Version(),
+		Config: ConfigSummary{
+			Database:       "sqlite",
+			Peers:          len(s.config.Peers),
+			SyncInterval:   time.Duration(s.config.SyncInterval).String(),
+			AdminEnabled:   s.config.AdminToken != "" || len(s.config.OperatorKeys) > 0,
+			BackupInterval: time.Duration(s.config.BackupInterval).String(),
+			AllowedOrigins: s.config.CORS.AllowedOrigins,
+			LogLevel:       s.config.Log.Level.String(),
+			AccessMode:     s.config.Access.Mode,
+		},
+	}
+	if isPostgresURL(s.config.Database) {
+		info.Config.Database = "postgres"
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				info.Commit = setting.Value
+			case "vcs.time":
+				info.BuildTime = setting.Value
+			case "vcs.modified":
+				info.Modified = setting.Value == "true"
+			}
+		}
+		if info.Version == "dev" && build.Main.Version != "" && build.Main.Version != "(devel)" {
+			info.Version = build.Main.Version
+		}
+	}
+	return info
+}
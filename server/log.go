@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// LogConfig controls the server log and the access log. Both are written as
+// JSON, one object per line.
+type LogConfig struct {
+	// Level is the lowest level written to the server log: "debug", "info",
+	// "warn" or "error". Failed requests are logged at debug level, or
+	// error level for server faults.
+	Level slog.Level `json:"level"`
+	// File is where the server log goes: "stderr", "stdout", a path, or
+	// "off".
+	File string `json:"file"`
+	// AccessLog is where a line per request goes: "stdout", "stderr", a
+	// path, or "off".
+	AccessLog string `json:"access_log"`
+}
+
+// OpenLogOutput returns the writer for a LogConfig destination. Files are
+// opened for appending. For "off" it returns nil.
+func OpenLogOutput(dest string) (io.WriteCloser, error) {
+	switch dest {
+	case "off":
+		return nil, nil
+	case "", "stderr":
+		return nopCloser{os.Stderr}, nil
+	case "stdout":
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.OpenFile(dest, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// NewLogger returns a JSON logger writing to w. Records logged with the
+// context of a request carry its request id.
+func NewLogger(w io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(contextHandler{slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})})
+}
+
+// contextHandler adds the request id found in the context to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// SetLogOutput redirects the server log and the access log. A nil writer
+// turns that log off.
+func (s *Server) SetLogOutput(log, access io.Writer) {
+	if log == nil {
+		log = io.Discard
+	}
+	s.log = NewLogger(log, s.config.Log.Level)
+	s.accessLog = nil
+	if access != nil {
+		s.accessLog = NewLogger(access, slog.LevelInfo)
+	}
+}
+
+// Logger returns the server log.
+func (s *Server) Logger() *slog.Logger {
+	return s.log
+}
+
+// accessLogMiddleware writes a line to the access log for every request.
+func (s *Server) accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.accessLog == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+
+		s.accessLog.LogAttrs(r.Context(), slog.LevelInfo, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.RequestURI()),
+			slog.Int("status", sw.status),
+			slog.Int64("bytes", sw.bytes),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.String("user_agent", r.UserAgent()),
+		)
+	})
+}
+
+// statusWriter records the status and size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps streamed feeds working through the wrapper.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeLogLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &record), line)
+		lines = append(lines, record)
+	}
+	return lines
+}
+
+func TestAccessLog(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	var logs, access bytes.Buffer
+	srv.SetLogOutput(&logs, &access)
+
+	req := httptest.NewRequest("GET", "/status?limit=5&since=0", nil)
+	req.Header.Set(RequestIDHeader, "req-1")
+	req.Header.Set("User-Agent", "test-agent")
+	rr := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	lines := decodeLogLines(t, &access)
+	require.Len(t, lines, 1)
+	assert.Equal(t, "request", lines[0]["msg"])
+	assert.Equal(t, "GET", lines[0]["method"])
+	assert.Equal(t, "/status?limit=5&since=0", lines[0]["path"])
+	assert.Equal(t, float64(http.StatusOK), lines[0]["status"])
+	assert.Equal(t, float64(rr.Body.Len()), lines[0]["bytes"])
+	assert.Equal(t, "test-agent", lines[0]["user_agent"])
+	assert.Equal(t, "req-1", lines[0]["request_id"])
+	assert.Empty(t, logs.String())
+}
+
+func TestErrorLogLevels(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	srv.config.Log.Level = slog.LevelDebug
+	var logs bytes.Buffer
+	srv.SetLogOutput(&logs, nil)
+
+	req := httptest.NewRequest("GET", "/nope", nil)
+	req.Header.Set(RequestIDHeader, "req-2")
+	rr := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rr, req)
+	require.Equal(t, http.StatusNotFound, rr.Code)
+
+	lines := decodeLogLines(t, &logs)
+	require.Len(t, lines, 1)
+	assert.Equal(t, "DEBUG", lines[0]["level"])
+	assert.Equal(t, "request failed", lines[0]["msg"])
+	assert.Equal(t, CodeNotFound, lines[0]["code"])
+	assert.Equal(t, float64(http.StatusNotFound), lines[0]["status"])
+	assert.Equal(t, "req-2", lines[0]["request_id"])
+
+	// Client errors are below the default level.
+	srv.config.Log.Level = slog.LevelInfo
+	logs.Reset()
+	srv.SetLogOutput(&logs, nil)
+	srv.Handler().ServeHTTP(httptest.NewRecorder(), req)
+	assert.Empty(t, logs.String())
+}
+
+func TestLogConfig(t *testing.T) {
+	t.Parallel()
+	var cfg Config
+	require.NoError(t, json.Unmarshal([]byte(`{"log":{"level":"warn","access_log":"off"}}`), &cfg))
+	assert.Equal(t, slog.LevelWarn, cfg.Log.Level)
+
+	out, err := OpenLogOutput(cfg.Log.AccessLog)
+	require.NoError(t, err)
+	assert.Nil(t, out)
+}
@@ -0,0 +1,456 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// MediaMaxPixels bounds the dimensions of uploaded images, so a small file
+// cannot decode into gigabytes of pixels.
+const MediaMaxPixels = 40_000_000
+
+// MediaUploadInterval and MediaUploadBurst limit how fast one key can
+// upload files.
+const (
+	MediaUploadInterval = 10 * time.Second
+	MediaUploadBurst    = 5
+)
+
+// mediaCleanupInterval is how often uploads no post attaches are looked
+// for.
+const mediaCleanupInterval = time.Hour
+
+// uploadMediaRoute names the upload route so signatureMiddleware lets its
+// body grow to MediaConfig.MaxSize.
+const uploadMediaRoute = "upload-media"
+
+// MediaConfig controls uploads of files posts can attach. Files are stored
+// under Dir named by their SHA-256, so the same bytes are only kept once.
+type MediaConfig struct {
+	// Dir is where uploads are written; empty, the default, disables
+	// uploads.
+	Dir string `json:"dir"`
+	// MaxSize is the largest upload accepted, in bytes.
+	MaxSize int64 `json:"max_size"`
+	// QuotaPerKey is how many bytes of uploads one key may have stored;
+	// zero means no limit.
+	QuotaPerKey int64 `json:"quota_per_key"`
+	// UnattachedTTL is how long an upload is kept when no post attaches
+	// it.
+	UnattachedTTL Duration `json:"unattached_ttl"`
+	// AllowedTypes are the MIME types accepted, as sniffed from the
+	// bytes rather than taken from the request.
+	AllowedTypes []string `json:"allowed_types"`
+	// ThumbnailSize is the longest side of the thumbnails made for PNG,
+	// JPEG and GIF images; zero turns thumbnails off.
+	ThumbnailSize int `json:"thumbnail_size"`
+}
+
+// Media describes an uploaded file. Width and Height are zero for files
+// that are not images the server can decode.
+type Media struct {
+	Hash      string `json:"hash"`
+	MimeType  string `json:"mime_type" db:"mime_type"`
+	Size      int64  `json:"size"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Thumbnail bool   `json:"thumbnail"`
+	Pubkey    string `json:"pubkey"`
+	Created   int64  `json:"created"`
+}
+
+// Hashes is a list of hex SHA-256 hashes, stored space separated in a
+// single column.
+type Hashes []string
+
+func (h Hashes) Value() (driver.Value, error) {
+	return strings.Join(h, " "), nil
+}
+
+func (h *Hashes) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case nil:
+	default:
+		return fmt.Errorf("cannot scan %T into Hashes", src)
+	}
+	*h = nil
+	if s != "" {
+		*h = strings.Fields(s)
+	}
+	return nil
+}
+
+// validHash reports whether hash is a lowercase hex SHA-256.
+func validHash(hash string) bool {
+	if len(hash) != sha256.Size*2 || strings.ToLower(hash) != hash {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+func validateAttachments(attachments Hashes) error {
+	if len(attachments) > AttachmentsMax {
+		return &ValidationError{Code: CodeInvalidAttachment, Field: "attachments", Message: fmt.Sprintf("a post can have at most %d attachments", AttachmentsMax)}
+	}
+	for i, hash := range attachments {
+		if !validHash(hash) {
+			return &ValidationError{Code: CodeInvalidAttachment, Field: "attachments", Message: fmt.Sprintf("attachment %d is not a SHA-256 hash", i)}
+		}
+		if slices.Contains(attachments[:i], hash) {
+			return &ValidationError{Code: CodeInvalidAttachment, Field: "attachments", Message: fmt.Sprintf("attachment %d is repeated", i)}
+		}
+	}
+	return nil
+}
+
+// checkAttachments makes sure every attachment of a new post was uploaded
+// here, including posts synced from peers or imported, since media is not
+// copied along with them.
+func (s *Server) checkAttachments(update StatusUpdate) error {
+	for _, hash := range update.Attachments {
+		_, err := s.store.GetMedia(hash)
+		if err == sql.ErrNoRows {
+			return &ValidationError{Code: CodeUnknownAttachment, Field: "attachments", Message: fmt.Sprintf("attachment %s was not uploaded", hash)}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) mediaPath(hash string) string {
+	return filepath.Join(s.config.Media.Dir, hash[:2], hash)
+}
+
+func (s *Server) thumbnailPath(hash string) string {
+	return s.mediaPath(hash) + ".thumb.png"
+}
+
+// mediaAllowed reports whether mimeType, as sniffed, is in the allowed
+// types. Parameters such as charset are ignored.
+func (cfg MediaConfig) mediaAllowed(mimeType string) bool {
+	base, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range cfg.AllowedTypes {
+		if t, _, err := mime.ParseMediaType(allowed); err == nil && t == base {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadMediaHandler serves POST /media. The body is the raw file and the
+// request must be signed by a key allowed to post. Each key has its own
+// rate limit and byte quota.
+func (s *Server) uploadMediaHandler(w http.ResponseWriter, r *http.Request) {
+	if s.config.Media.Dir == "" {
+		s.handleError(w, r, CodeMediaDisabled, "Media uploads are disabled", http.StatusForbidden)
+		return
+	}
+	if err := s.checkPubkeyAccess(signer(r)); err != nil {
+		s.handleAccessError(w, r, err)
+		return
+	}
+	if !s.allow(w, s.mediaUploaders.get(signer(r))) {
+		s.handleError(w, r, CodeRateLimited, "Rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	// signatureMiddleware has already read the body into memory.
+	data, err := io.ReadAll(io.LimitReader(r.Body, s.config.Media.MaxSize+1))
+	if err != nil {
+		s.handleError(w, r, CodeInvalidPayload, "Error reading upload", http.StatusBadRequest)
+		return
+	}
+	if int64(len(data)) > s.config.Media.MaxSize {
+		s.handleError(w, r, CodePayloadTooLarge, fmt.Sprintf("Uploads are limited to %d bytes", s.config.Media.MaxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if len(data) == 0 {
+		s.handleError(w, r, CodeInvalidPayload, "Upload is empty", http.StatusBadRequest)
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	media := Media{
+		Hash:     hex.EncodeToString(sum[:]),
+		MimeType: http.DetectContentType(data),
+		Size:     int64(len(data)),
+		Pubkey:   signer(r),
+	}
+	if !s.config.Media.mediaAllowed(media.MimeType) {
+		s.handleError(w, r, CodeMediaTypeNotAllowed, fmt.Sprintf("Files of type %s are not accepted", media.MimeType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	existing, err := s.store.GetMedia(media.Hash)
+	if err == nil {
+		s.render(w, r, http.StatusOK, existing)
+		return
+	}
+	if err != sql.ErrNoRows {
+		s.handleError(w, r, CodeInternal, "Error checking media", http.StatusInternalServerError)
+		return
+	}
+	if quota := s.config.Media.QuotaPerKey; quota > 0 {
+		usage, err := s.store.MediaUsage(media.Pubkey)
+		if err != nil {
+			s.handleError(w, r, CodeInternal, "Error checking media", http.StatusInternalServerError)
+			return
+		}
+		if usage+media.Size > quota {
+			s.handleError(w, r, CodeMediaQuotaExceeded, fmt.Sprintf("Uploads are limited to %d bytes per key", quota), http.StatusForbidden)
+			return
+		}
+	}
+
+	var img image.Image
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		if cfg.Width*cfg.Height > MediaMaxPixels {
+			s.handleError(w, r, CodeImageTooLarge, fmt.Sprintf("Images are limited to %d pixels", MediaMaxPixels), http.StatusUnprocessableEntity)
+			return
+		}
+		media.Width, media.Height = cfg.Width, cfg.Height
+		if s.config.Media.ThumbnailSize > 0 {
+			img, _, _ = image.Decode(bytes.NewReader(data))
+		}
+	}
+
+	if err := writeFileAtomic(s.mediaPath(media.Hash), func(f *os.File) error {
+		_, err := f.Write(data)
+		return err
+	}); err != nil {
+		s.log.ErrorContext(r.Context(), "error writing media", "hash", media.Hash, "error", err)
+		s.handleError(w, r, CodeInternal, "Error storing media", http.StatusInternalServerError)
+		return
+	}
+	if img != nil {
+		thumb := thumbnail(img, s.config.Media.ThumbnailSize)
+		err := writeFileAtomic(s.thumbnailPath(media.Hash), func(f *os.File) error {
+			return png.Encode(f, thumb)
+		})
+		if err != nil {
+			// The upload is still usable without one.
+			s.log.ErrorContext(r.Context(), "error writing thumbnail", "hash", media.Hash, "error", err)
+		}
+		media.Thumbnail = err == nil
+	}
+
+	if err := s.store.AddMedia(&media); err != nil {
+		s.handleError(w, r, CodeInternal, "Error storing media", http.StatusInternalServerError)
+		return
+	}
+	s.render(w, r, http.StatusCreated, media)
+}
+
+// getMediaHandler serves GET /media/{hash}, the uploaded bytes. Content
+// never changes for a hash, so it may be cached forever.
+func (s *Server) getMediaHandler(w http.ResponseWriter, r *http.Request) {
+	media, ok := s.lookupMedia(w, r)
+	if !ok {
+		return
+	}
+	if !strings.HasPrefix(media.MimeType, "image/") {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", media.Hash))
+	}
+	s.serveMediaFile(w, r, s.mediaPath(media.Hash), media.MimeType, media)
+}
+
+// getThumbnailHandler serves GET /media/{hash}/thumbnail, a PNG no larger
+// than MediaConfig.ThumbnailSize on either side.
+func (s *Server) getThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	media, ok := s.lookupMedia(w, r)
+	if !ok {
+		return
+	}
+	if !media.Thumbnail {
+		s.handleError(w, r, CodeNotFound, "Media has no thumbnail", http.StatusNotFound)
+		return
+	}
+	s.serveMediaFile(w, r, s.thumbnailPath(media.Hash), "image/png", media)
+}
+
+func (s *Server) lookupMedia(w http.ResponseWriter, r *http.Request) (Media, bool) {
+	media, err := s.store.GetMedia(mux.Vars(r)["hash"])
+	if err == sql.ErrNoRows {
+		s.handleError(w, r, CodeNotFound, "Media not found", http.StatusNotFound)
+		return media, false
+	}
+	if err != nil {
+		s.handleError(w, r, CodeInternal, "Error retrieving media", http.StatusInternalServerError)
+		return media, false
+	}
+	return media, true
+}
+
+// serveMediaFile sends a stored file with headers that keep browsers from
+// treating uploads as anything but the type they were sniffed as.
+func (s *Server) serveMediaFile(w http.ResponseWriter, r *http.Request, path, mimeType string, media Media) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.handleError(w, r, CodeNotFound, "Media not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.handleError(w, r, CodeInternal, "Error reading media", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	h := w.Header()
+	h.Set("Content-Type", mimeType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	h.Set("ETag", `"`+media.Hash+`"`)
+	http.ServeContent(w, r, "", time.Unix(media.Created, 0), f)
+}
+
+// runMediaCleanup deletes uploads no post attached within
+// MediaConfig.UnattachedTTL until ctx is cancelled.
+func (s *Server) runMediaCleanup(ctx context.Context) {
+	if s.config.Media.Dir == "" {
+		return
+	}
+
+	ticker := time.NewTicker(mediaCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.cleanupMedia(time.Now()); err != nil {
+			s.log.Error("error cleaning up media", "error", err)
+		}
+	}
+}
+
+// cleanupMedia deletes the uploads older than MediaConfig.UnattachedTTL at
+// now that no post attaches, along with their files.
+func (s *Server) cleanupMedia(now time.Time) error {
+	const batch = 100
+	before := now.Add(-time.Duration(s.config.Media.UnattachedTTL)).Unix()
+	for {
+		deleted, err := s.store.DeleteUnattachedMedia(before, batch)
+		if err != nil {
+			return err
+		}
+		for _, media := range deleted {
+			for _, path := range []string{s.mediaPath(media.Hash), s.thumbnailPath(media.Hash)} {
+				if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+					s.log.Error("error removing media", "hash", media.Hash, "error", err)
+				}
+			}
+		}
+		if len(deleted) > 0 {
+			s.log.Info("unattached media removed", "count", len(deleted))
+		}
+		if len(deleted) < batch {
+			return nil
+		}
+	}
+}
+
+// writeFileAtomic writes a file through a temporary file in the same
+// directory, so readers never see a partial file.
+func writeFileAtomic(path string, write func(f *os.File) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// thumbnail scales img down so its longest side is at most size, averaging
+// a grid of about 4x4 source pixels for each pixel of the result. Images
+// already small enough are copied as they are.
+func thumbnail(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if longest := max(w, h); longest > size {
+		w = max(1, w*size/longest)
+		h = max(1, h*size/longest)
+	}
+
+	thumb := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/h, b.Min.Y+(y+1)*b.Dy()/h
+		for x := 0; x < w; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/w, b.Min.X+(x+1)*b.Dx()/w
+			thumb.Set(x, y, averageColor(img, x0, y0, max(x1, x0+1), max(y1, y0+1)))
+		}
+	}
+	return thumb
+}
+
+// averageColor averages about 4x4 pixels spread evenly over the rectangle
+// from (x0, y0) up to but not including (x1, y1).
+func averageColor(img image.Image, x0, y0, x1, y1 int) color.Color {
+	const samples = 4
+	stepX := max(1, (x1-x0)/samples)
+	stepY := max(1, (y1-y0)/samples)
+
+	var r, g, b, a, n uint64
+	for y := y0; y < y1; y += stepY {
+		for x := x0; x < x1; x += stepX {
+			cr, cg, cb, ca := img.At(x, y).RGBA()
+			r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+			n++
+		}
+	}
+	// The sums are premultiplied by alpha.
+	return color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)}
+}
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/donuts-are-good/postshortly/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func newMediaTestServer(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+	srv := newTestServer(t)
+	srv.limiter = rate.NewLimiter(rate.Inf, 1)
+	srv.mediaUploaders.limit = rate.Inf
+	srv.config.Media.Dir = t.TempDir()
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	return srv, ts
+}
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func requireAPIError(t *testing.T, err error, status int, code string) {
+	t.Helper()
+	var apiErr *sdk.APIError
+	require.True(t, errors.As(err, &apiErr), "got %v", err)
+	assert.Equal(t, status, apiErr.StatusCode)
+	assert.Equal(t, code, apiErr.Code)
+}
+
+func TestMediaUpload(t *testing.T) {
+	t.Parallel()
+	_, ts := newMediaTestServer(t)
+	ctx := context.Background()
+
+	_, priv, _ := sdk.GenerateKey()
+	client := sdk.NewClient(ts.URL)
+	client.Key = priv
+
+	data := testPNG(t, 600, 300)
+	sum := sha256.Sum256(data)
+	media, err := client.UploadMedia(ctx, data)
+	require.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(sum[:]), media.Hash)
+	assert.Equal(t, "image/png", media.MimeType)
+	assert.Equal(t, int64(len(data)), media.Size)
+	assert.Equal(t, 600, media.Width)
+	assert.Equal(t, 300, media.Height)
+	assert.True(t, media.Thumbnail)
+
+	again, err := client.UploadMedia(ctx, data)
+	require.NoError(t, err)
+	assert.Equal(t, media, again)
+
+	resp, err := http.Get(ts.URL + "/media/" + media.Hash)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "nosniff", resp.Header.Get("X-Content-Type-Options"))
+	assert.Contains(t, resp.Header.Get("Cache-Control"), "immutable")
+	var served bytes.Buffer
+	_, err = served.ReadFrom(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, data, served.Bytes())
+
+	resp, err = http.Get(ts.URL + "/media/" + media.Hash + "/thumbnail")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	thumb, err := png.Decode(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 320, 160), thumb.Bounds())
+
+	resp, err = http.Get(ts.URL + "/media/" + strings.Repeat("0", 64))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestMediaUploadRefused(t *testing.T) {
+	t.Parallel()
+	srv, ts := newMediaTestServer(t)
+	srv.config.Media.MaxSize = 1024
+	ctx := context.Background()
+
+	_, priv, _ := sdk.GenerateKey()
+	client := sdk.NewClient(ts.URL)
+	client.Key = priv
+
+	_, err := client.UploadMedia(ctx, []byte("just some text, not an image"))
+	requireAPIError(t, err, http.StatusUnsupportedMediaType, CodeMediaTypeNotAllowed)
+
+	// Only the header is read to find the size, so this needs no pixels.
+	huge := append([]byte("GIF89a"), 0xff, 0xff, 0xff, 0xff, 0, 0, 0, ';')
+	_, err = client.UploadMedia(ctx, huge)
+	requireAPIError(t, err, http.StatusUnprocessableEntity, CodeImageTooLarge)
+
+	_, err = client.UploadMedia(ctx, append(testPNG(t, 1, 1), make([]byte, 2048)...))
+	requireAPIError(t, err, http.StatusRequestEntityTooLarge, CodePayloadTooLarge)
+
+	_, err = sdk.NewClient(ts.URL).UploadMedia(ctx, testPNG(t, 1, 1))
+	requireAPIError(t, err, http.StatusUnauthorized, CodeUnauthorized)
+
+	srv.config.Media.Dir = ""
+	_, err = client.UploadMedia(ctx, testPNG(t, 1, 1))
+	requireAPIError(t, err, http.StatusForbidden, CodeMediaDisabled)
+}
+
+func TestMediaQuota(t *testing.T) {
+	t.Parallel()
+	srv, ts := newMediaTestServer(t)
+	ctx := context.Background()
+
+	_, priv, _ := sdk.GenerateKey()
+	client := sdk.NewClient(ts.URL)
+	client.Key = priv
+
+	first := testPNG(t, 8, 8)
+	srv.config.Media.QuotaPerKey = int64(len(first)) + 10
+	_, err := client.UploadMedia(ctx, first)
+	require.NoError(t, err)
+	_, err = client.UploadMedia(ctx, first)
+	require.NoError(t, err, "uploading the same file again is free")
+	_, err = client.UploadMedia(ctx, testPNG(t, 9, 9))
+	requireAPIError(t, err, http.StatusForbidden, CodeMediaQuotaExceeded)
+
+	other := sdk.NewClient(ts.URL)
+	_, other.Key, _ = sdk.GenerateKey()
+	_, err = other.UploadMedia(ctx, testPNG(t, 9, 9))
+	require.NoError(t, err, "the quota is per key")
+}
+
+func TestMediaUploadRateLimit(t *testing.T) {
+	t.Parallel()
+	srv, ts := newMediaTestServer(t)
+	srv.mediaUploaders.limit = rate.Every(MediaUploadInterval)
+	srv.mediaUploaders.burst = MediaUploadBurst
+	ctx := context.Background()
+
+	_, priv, _ := sdk.GenerateKey()
+	client := sdk.NewClient(ts.URL)
+	client.Key = priv
+	for i := 0; i < MediaUploadBurst; i++ {
+		_, err := client.UploadMedia(ctx, testPNG(t, 1, i+1))
+		require.NoError(t, err)
+	}
+	_, err := client.UploadMedia(ctx, testPNG(t, 1, 1))
+	requireAPIError(t, err, http.StatusTooManyRequests, CodeRateLimited)
+
+	// Posts have a limiter of their own.
+	srv.limiter = rate.NewLimiter(1, 1)
+	update := sdk.StatusUpdate{Body: "still posting"}
+	sdk.SignStatusUpdate(priv, &update)
+	_, err = client.Post(ctx, update)
+	require.NoError(t, err)
+}
+
+func TestCleanupMedia(t *testing.T) {
+	t.Parallel()
+	srv, ts := newMediaTestServer(t)
+	ctx := context.Background()
+
+	_, priv, _ := sdk.GenerateKey()
+	client := sdk.NewClient(ts.URL)
+	client.Key = priv
+	attached, err := client.UploadMedia(ctx, testPNG(t, 8, 8))
+	require.NoError(t, err)
+	loose, err := client.UploadMedia(ctx, testPNG(t, 9, 9))
+	require.NoError(t, err)
+	update := sdk.StatusUpdate{Body: "look", Attachments: []string{attached.Hash}}
+	sdk.SignStatusUpdate(priv, &update)
+	_, err = client.Post(ctx, update)
+	require.NoError(t, err)
+
+	require.NoError(t, srv.cleanupMedia(time.Now()))
+	assert.FileExists(t, srv.mediaPath(loose.Hash), "recent uploads are kept")
+
+	require.NoError(t, srv.cleanupMedia(time.Now().Add(time.Duration(srv.config.Media.UnattachedTTL)+time.Second)))
+	assert.NoFileExists(t, srv.mediaPath(loose.Hash))
+	assert.NoFileExists(t, srv.thumbnailPath(loose.Hash))
+	_, err = srv.store.GetMedia(loose.Hash)
+	assert.Equal(t, sql.ErrNoRows, err)
+	assert.FileExists(t, srv.mediaPath(attached.Hash))
+	_, err = srv.store.GetMedia(attached.Hash)
+	assert.NoError(t, err)
+}
+
+func TestPostWithAttachments(t *testing.T) {
+	t.Parallel()
+	_, ts := newMediaTestServer(t)
+	ctx := context.Background()
+
+	_, priv, _ := sdk.GenerateKey()
+	client := sdk.NewClient(ts.URL)
+	client.Key = priv
+	media, err := client.UploadMedia(ctx, testPNG(t, 8, 8))
+	require.NoError(t, err)
+
+	unknown := sdk.StatusUpdate{Body: "look", Attachments: []string{strings.Repeat("0", 64)}}
+	sdk.SignStatusUpdate(priv, &unknown)
+	_, err = client.Post(ctx, unknown)
+	requireAPIError(t, err, http.StatusBadRequest, CodeUnknownAttachment)
+
+	update := sdk.StatusUpdate{Body: "look", Attachments: []string{media.Hash}}
+	sdk.SignStatusUpdate(priv, &update)
+	tampered := update
+	tampered.Attachments = nil
+	_, err = client.Post(ctx, tampered)
+	requireAPIError(t, err, http.StatusBadRequest, CodeSignatureMismatch)
+
+	created, err := client.Post(ctx, update)
+	require.NoError(t, err)
+	assert.Equal(t, []string{media.Hash}, created.Attachments)
+
+	feed, err := client.Feed(ctx)
+	require.NoError(t, err)
+	require.Len(t, feed, 1)
+	assert.Equal(t, []string{media.Hash}, feed[0].Attachments)
+	assert.NoError(t, sdk.VerifyStatusUpdate(feed[0]))
+}
+
+func TestValidateAttachments(t *testing.T) {
+	t.Parallel()
+	hash := strings.Repeat("ab", 32)
+	tests := []struct {
+		name        string
+		attachments Hashes
+		ok          bool
+	}{
+		{"none", nil, true},
+		{"one", Hashes{hash}, true},
+		{"too many", Hashes{hash, strings.Repeat("01", 32), strings.Repeat("02", 32), strings.Repeat("03", 32), strings.Repeat("04", 32)}, false},
+		{"short", Hashes{"abcd"}, false},
+		{"not hex", Hashes{strings.Repeat("zz", 32)}, false},
+		{"uppercase", Hashes{strings.ToUpper(hash)}, false},
+		{"repeated", Hashes{hash, hash}, false},
+	}
+	for _, tt := range tests {
+		err := validateAttachments(tt.attachments)
+		if tt.ok {
+			assert.NoError(t, err, tt.name)
+		} else {
+			assert.Error(t, err, tt.name)
+		}
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	t.Parallel()
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 400))
+	assert.Equal(t, image.Rect(0, 0, 25, 100), thumbnail(img, 100).Bounds())
+	assert.Equal(t, image.Rect(0, 0, 100, 400), thumbnail(img, 1000).Bounds(), "small images keep their size")
+
+	// A solid colour stays the same when averaged.
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	r, g, b, a := thumbnail(img, 10).At(1, 1).RGBA()
+	er, eg, eb, ea := img.At(0, 0).RGBA()
+	assert.Equal(t, []uint32{er, eg, eb, ea}, []uint32{r, g, b, a})
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/nacl/box"
+)
+
+const (
+	// MessageMaxSize bounds the sealed bytes of a direct message.
+	MessageMaxSize = 8 * 1024
+	// MailboxMaxMessages is how many messages a mailbox holds before
+	// new ones are refused.
+	MailboxMaxMessages = 1000
+	// MailboxMaxPerSender is how many of those messages may come from one
+	// sender, so a single key cannot fill someone's mailbox.
+	MailboxMaxPerSender = 50
+	// MailboxPageSize is how many messages GET /messages returns when no
+	// limit is given.
+	MailboxPageSize = 100
+	// MessageTTL is how long a message waits to be read before it is
+	// dropped.
+	MessageTTL = 30 * 24 * time.Hour
+	// MessageSenderInterval and MessageSenderBurst limit how fast one key
+	// can send messages.
+	MessageSenderInterval = 10 * time.Second
+	MessageSenderBurst    = 10
+	// MessageRecipientInterval and MessageRecipientBurst limit how fast one
+	// mailbox takes messages, so fresh keys cannot fill it quickly either.
+	MessageRecipientInterval = time.Minute
+	MessageRecipientBurst    = 30
+)
+
+var (
+	// ErrMailboxFull is returned by Store.AddMessage when the recipient
+	// already has MailboxMaxMessages messages.
+	ErrMailboxFull = errors.New("mailbox is full")
+	// ErrTooManyFromSender is returned by Store.AddMessage when the
+	// recipient already has MailboxMaxPerSender messages from the sender.
+	ErrTooManyFromSender = errors.New("too many messages from sender")
+)
+
+// Message is a direct message sealed to its recipient's key. The server
+// stores it as opaque bytes; see sdk.SealDirectMessage.
+type Message struct {
+	ID         int    `json:"id"`
+	Recipient  string `json:"recipient"`
+	Ciphertext []byte `json:"ciphertext"`
+	Created    int64  `json:"created"`
+	// SenderHash identifies the sender within the recipient's mailbox
+	// without storing their key; see messageSenderHash.
+	SenderHash string `json:"-" db:"sender_hash"`
+}
+
+// messageSenderHash returns the hex SHA-256 of the recipient and sender
+// keys, which lets the mailbox count messages per sender. It only keeps
+// the sender from being read off the row directly: anyone with the
+// database can still test it against keys they know.
+func messageSenderHash(recipient, sender string) string {
+	sum := sha256.Sum256([]byte(recipient + "\n" + sender))
+	return hex.EncodeToString(sum[:])
+}
+
+// sendMessageHandler serves POST /messages/{pubkey}. The request must be
+// signed, so banned and denied keys cannot send, but only a hash of the
+// sender is stored with the message. Messages are rate limited per sender
+// and per recipient rather than by the posting limiter.
+func (s *Server) sendMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.checkPubkeyAccess(signer(r)); err != nil {
+		s.handleAccessError(w, r, err)
+		return
+	}
+
+	recipient := strings.ToLower(mux.Vars(r)["pubkey"])
+	if raw, err := hex.DecodeString(recipient); err != nil || len(raw) != PubkeyMaxSize {
+		s.writeError(w, r, ErrorResponse{Code: CodeInvalidPubkey, Message: "invalid recipient pubkey", Field: "pubkey"}, http.StatusBadRequest)
+		return
+	}
+
+	var msg Message
+	// Base64 takes four bytes for every three.
+	if !s.decodeJSON(w, r, &msg, MessageMaxSize*4/3+1024) {
+		return
+	}
+	if len(msg.Ciphertext) <= box.AnonymousOverhead || len(msg.Ciphertext) > MessageMaxSize {
+		s.writeError(w, r, ErrorResponse{
+			Code:    CodeInvalidPayload,
+			Message: fmt.Sprintf("ciphertext must be a sealed box of at most %d bytes", MessageMaxSize),
+			Field:   "ciphertext",
+		}, http.StatusBadRequest)
+		return
+	}
+
+	if !s.allow(w, s.messageSenders.get(signer(r))) {
+		s.handleError(w, r, CodeRateLimited, "Rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+	if !s.allow(w, s.messageRecipients.get(recipient)) {
+		s.handleError(w, r, CodeRateLimited, "Recipient is receiving too many messages", http.StatusTooManyRequests)
+		return
+	}
+
+	msg = Message{Recipient: recipient, Ciphertext: msg.Ciphertext, SenderHash: messageSenderHash(recipient, signer(r))}
+	err := s.store.AddMessage(&msg)
+	if errors.Is(err, ErrMailboxFull) {
+		s.handleError(w, r, CodeMailboxFull, "Recipient's mailbox is full", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, ErrTooManyFromSender) {
+		s.handleError(w, r, CodeMailboxFull, "Recipient's mailbox holds too many of your messages", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		s.handleError(w, r, CodeInternal, "Error storing message", http.StatusInternalServerError)
+		return
+	}
+	s.render(w, r, http.StatusCreated, msg)
+}
+
+// getMessagesHandler serves GET /messages?since=<id>&limit=<n>, the
+// mailbox of the key that signed the request, oldest first.
+func (s *Server) getMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	since := 0
+	if query.Has("since") {
+		var err error
+		if since, err = strconv.Atoi(query.Get("since")); err != nil || since < 0 {
+			s.writeError(w, r, ErrorResponse{Code: CodeInvalidParameter, Message: "Invalid since cursor", Field: "since"}, http.StatusBadRequest)
+			return
+		}
+	}
+	limit, ok := s.listLimit(w, r, MailboxPageSize, MailboxMaxMessages)
+	if !ok {
+		return
+	}
+
+	messages, err := s.store.GetMessages(signer(r), since, limit)
+	if err != nil {
+		s.handleError(w, r, CodeInternal, "Error retrieving messages", http.StatusInternalServerError)
+		return
+	}
+	s.render(w, r, http.StatusOK, messages)
+}
+
+// deleteMessageHandler serves DELETE /messages/{id}. Only the recipient
+// can delete a message.
+func (s *Server) deleteMessageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		s.handleError(w, r, CodeNotFound, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	deleted, err := s.store.DeleteMessage(signer(r), id)
+	if err != nil {
+		s.handleError(w, r, CodeInternal, "Error deleting message", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		s.handleError(w, r, CodeNotFound, "Message not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/donuts-are-good/postshortly/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestDirectMessages(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	srv.limiter = rate.NewLimiter(rate.Inf, 1)
+	srv.messageSenders.limit = rate.Inf
+	srv.messageRecipients.limit = rate.Inf
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	ctx := context.Background()
+
+	_, alicePriv, _ := sdk.GenerateKey()
+	bob, bobPriv, _ := sdk.GenerateKey()
+	alice := sdk.NewClient(ts.URL)
+	alice.Key = alicePriv
+	bobClient := sdk.NewClient(ts.URL)
+	bobClient.Key = bobPriv
+
+	sent, err := alice.SendDirectMessage(ctx, bob, "see you at noon")
+	require.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(bob), sent.Recipient)
+
+	// Alice's own mailbox is empty; Bob's has the message.
+	mine, err := alice.Mailbox(ctx, 0, 10)
+	require.NoError(t, err)
+	assert.Empty(t, mine)
+	inbox, err := bobClient.Mailbox(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, inbox, 1)
+
+	msg, err := sdk.OpenDirectMessage(bobPriv, inbox[0].Ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, "see you at noon", msg.Body)
+
+	// Only the recipient can delete it.
+	var apiErr *sdk.APIError
+	err = alice.DeleteMessage(ctx, inbox[0].ID)
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	require.NoError(t, bobClient.DeleteMessage(ctx, inbox[0].ID))
+	inbox, err = bobClient.Mailbox(ctx, 0, 10)
+	require.NoError(t, err)
+	assert.Empty(t, inbox)
+
+	// Mailboxes need a signed request.
+	_, err = sdk.NewClient(ts.URL).Mailbox(ctx, 0, 10)
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+}
+
+func TestSendMessageValidation(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	srv.limiter = rate.NewLimiter(rate.Inf, 1)
+	srv.messageSenders.limit = rate.Inf
+	srv.messageRecipients.limit = rate.Inf
+	handler := srv.Handler()
+	_, priv, _ := sdk.GenerateKey()
+	recipient := strings.Repeat("ab", PubkeyMaxSize)
+
+	send := func(path, body string) int {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		require.NoError(t, sdk.SignRequest(req, priv))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusBadRequest, send("/messages/abc", `{"ciphertext":"AAAA"}`))
+	assert.Equal(t, http.StatusBadRequest, send("/messages/"+recipient, `{"ciphertext":"AAAA"}`), "too short for a sealed box")
+	assert.Equal(t, http.StatusBadRequest, send("/messages/"+recipient, `{"ciphertext":"`+strings.Repeat("A", (MessageMaxSize+3)/3*4)+`"}`))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, send("/messages/"+recipient, `{"ciphertext":"`+strings.Repeat("A", MessageMaxSize*2)+`"}`))
+
+	for i := 0; i < MailboxMaxMessages; i++ {
+		require.NoError(t, srv.store.AddMessage(&Message{Recipient: recipient, Ciphertext: []byte("x")}))
+	}
+	to, _ := hex.DecodeString(recipient)
+	sealed, err := sdk.SealDirectMessage(priv, to, "hi")
+	require.NoError(t, err)
+	body, _ := json.Marshal(Message{Ciphertext: sealed})
+	assert.Equal(t, http.StatusForbidden, send("/messages/"+recipient, string(body)), "mailbox full")
+}
+
+func TestSendMessageLimits(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	handler := srv.Handler()
+	to, _, _ := sdk.GenerateKey()
+	recipient := hex.EncodeToString(to)
+
+	send := func(priv ed25519.PrivateKey) int {
+		sealed, err := sdk.SealDirectMessage(priv, to, "hi")
+		require.NoError(t, err)
+		body, _ := json.Marshal(Message{Ciphertext: sealed})
+		req := httptest.NewRequest("POST", "/messages/"+recipient, strings.NewReader(string(body)))
+		req.Header.Set("Content-Type", "application/json")
+		require.NoError(t, sdk.SignRequest(req, priv))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// Messages don't spend the posting rate limit.
+	require.True(t, srv.limiter.Allow())
+	spammer, spammerPriv, _ := sdk.GenerateKey()
+	assert.Equal(t, http.StatusCreated, send(spammerPriv))
+
+	// Each sender has its own limit...
+	for i := 1; i < MessageSenderBurst; i++ {
+		require.Equal(t, http.StatusCreated, send(spammerPriv))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, send(spammerPriv))
+	_, otherPriv, _ := sdk.GenerateKey()
+	assert.Equal(t, http.StatusCreated, send(otherPriv))
+
+	// ...and so does each mailbox, which fresh keys don't get around.
+	for i := MessageSenderBurst + 1; i < MessageRecipientBurst; i++ {
+		_, fresh, _ := sdk.GenerateKey()
+		require.Equal(t, http.StatusCreated, send(fresh))
+	}
+	_, fresh, _ := sdk.GenerateKey()
+	assert.Equal(t, http.StatusTooManyRequests, send(fresh))
+
+	// One sender can only hold part of a mailbox.
+	srv.messageSenders = keyLimiter{limit: rate.Inf}
+	srv.messageRecipients = keyLimiter{limit: rate.Inf}
+	for i := MessageSenderBurst; i < MailboxMaxPerSender; i++ {
+		msg := Message{Recipient: recipient, Ciphertext: []byte("x"), SenderHash: messageSenderHash(recipient, hex.EncodeToString(spammer))}
+		require.NoError(t, srv.store.AddMessage(&msg))
+	}
+	assert.Equal(t, http.StatusForbidden, send(spammerPriv))
+	assert.Equal(t, http.StatusCreated, send(otherPriv))
+}
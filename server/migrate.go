@@ -0,0 +1,172 @@
+package server
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations live in migrations/<dialect>/NNNN_name.sql and are applied in
+// order. Each file runs in its own transaction together with the
+// schema_version row recording it. Applied migrations must never be edited;
+// schema changes always go in a new file, for every dialect.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+const schemaVersionTable = `
+CREATE TABLE IF NOT EXISTS schema_version (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at BIGINT NOT NULL
+)`
+
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+func loadMigrations(dialect string) ([]migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || !strings.HasSuffix(entry.Name(), ".sql") {
+			return nil, fmt.Errorf("bad migration file name %s", entry.Name())
+		}
+
+		data, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{Version: version, Name: name, SQL: string(data)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %s is out of sequence", m.Name)
+		}
+	}
+	return migrations, nil
+}
+
+// SchemaVersion returns the version the database is at and the latest
+// version this binary knows about.
+func (s *sqlStore) SchemaVersion() (current, latest int, err error) {
+	migrations, err := loadMigrations(s.dialect)
+	if err != nil {
+		return 0, 0, err
+	}
+	latest = len(migrations)
+
+	if _, err := s.db.Exec(schemaVersionTable); err != nil {
+		return 0, latest, err
+	}
+	if err := s.adoptLegacySchema(); err != nil {
+		return 0, latest, err
+	}
+
+	err = s.db.Get(&current, "SELECT COALESCE(MAX(version), 0) FROM schema_version")
+	return current, latest, err
+}
+
+// adoptLegacySchema records the initial migration as applied for databases
+// created before schema versioning, which already have its tables.
+func (s *sqlStore) adoptLegacySchema() error {
+	var recorded int
+	if err := s.db.Get(&recorded, "SELECT COUNT(*) FROM schema_version"); err != nil || recorded > 0 {
+		return err
+	}
+	if _, err := s.db.Exec("SELECT 1 FROM status_updates LIMIT 1"); err != nil {
+		// No legacy tables; the initial migration creates them.
+		return nil
+	}
+
+	_, err := s.db.Exec(s.db.Rebind("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)"),
+		1, "0001_init (adopted)", time.Now().Unix())
+	return err
+}
+
+// Migrate applies every pending migration and returns their names.
+func (s *sqlStore) Migrate() ([]string, error) {
+	current, latest, err := s.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if current > latest {
+		return nil, errSchemaTooNew(current, latest)
+	}
+
+	migrations, err := loadMigrations(s.dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []string
+	for _, m := range migrations[current:] {
+		if err := s.applyMigration(m); err != nil {
+			return applied, fmt.Errorf("error applying migration %s: %v", m.Name, err)
+		}
+		applied = append(applied, m.Name)
+	}
+	return applied, nil
+}
+
+func (s *sqlStore) applyMigration(m migration) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.SQL); err != nil {
+		return err
+	}
+	_, err = tx.Exec(tx.Rebind("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)"),
+		m.Version, m.Name, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func errSchemaTooNew(current, latest int) error {
+	return fmt.Errorf("database schema version %d is newer than the latest version this build supports (%d); upgrade postshortly", current, latest)
+}
+
+// checkSchema makes sure a store is safe to use: it refuses databases
+// migrated by a newer build and, unless autoMigrate is set, databases with
+// pending migrations.
+func checkSchema(s Store, autoMigrate bool) error {
+	current, latest, err := s.SchemaVersion()
+	if err != nil {
+		return fmt.Errorf("error reading schema version: %v", err)
+	}
+
+	switch {
+	case current > latest:
+		return errSchemaTooNew(current, latest)
+	case current < latest && !autoMigrate:
+		return fmt.Errorf("database schema version %d is behind the latest version %d; run 'postshortly migrate'", current, latest)
+	case current < latest:
+		_, err := s.Migrate()
+		return err
+	}
+	return nil
+}
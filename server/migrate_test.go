@@ -0,0 +1,114 @@
+package server
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	sqlite, err := loadMigrations("sqlite")
+	require.NoError(t, err)
+	postgres, err := loadMigrations("postgres")
+	require.NoError(t, err)
+
+	// Every dialect has to move through the same versions.
+	require.Equal(t, len(sqlite), len(postgres))
+	for i := range sqlite {
+		assert.Equal(t, sqlite[i].Name, postgres[i].Name)
+	}
+}
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	s, err := ConnectStore(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	current, latest, err := s.SchemaVersion()
+	require.NoError(t, err)
+	assert.Zero(t, current)
+
+	applied, err := s.Migrate()
+	require.NoError(t, err)
+	assert.Len(t, applied, latest)
+
+	current, _, err = s.SchemaVersion()
+	require.NoError(t, err)
+	assert.Equal(t, latest, current)
+
+	applied, err = s.Migrate()
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	migrations, err := loadMigrations("sqlite")
+	require.NoError(t, err)
+
+	// A database written by a build from before schema versioning.
+	legacy, err := sqlx.Connect("sqlite3", path)
+	require.NoError(t, err)
+	_, err = legacy.Exec(migrations[0].SQL)
+	require.NoError(t, err)
+	// The same post twice, as left behind by a client retrying a POST, and
+	// once more with its hex in uppercase.
+	for timestamp := 1; timestamp <= 3; timestamp++ {
+		pubkey, signature := strings.Repeat("a", PubkeyMaxSize*2), strings.Repeat("b", SignatureMaxSize*2)
+		if timestamp == 3 {
+			pubkey, signature = strings.ToUpper(pubkey), strings.ToUpper(signature)
+		}
+		_, err = legacy.Exec("INSERT INTO status_updates (timestamp, body, link, pubkey, signature) VALUES (?, 'old post', '', ?, ?)",
+			timestamp, pubkey, signature)
+		require.NoError(t, err)
+	}
+	legacy.Close()
+
+	s, err := ConnectStore(path)
+	require.NoError(t, err)
+	defer s.Close()
+
+	current, _, err := s.SchemaVersion()
+	require.NoError(t, err)
+	assert.Equal(t, 1, current)
+
+	applied, err := s.Migrate()
+	require.NoError(t, err)
+	assert.Len(t, applied, len(migrations)-1)
+
+	updates, err := s.GetAllStatusUpdates()
+	require.NoError(t, err)
+	require.Len(t, updates, 1)
+	assert.Equal(t, "old post", updates[0].Body)
+	assert.Equal(t, int64(1), updates[0].Timestamp)
+	assert.Equal(t, "", updates[0].Origin)
+}
+
+func TestCheckSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := ConnectStore(path)
+	require.NoError(t, err)
+	defer s.Close()
+
+	err = checkSchema(s, false)
+	assert.ErrorContains(t, err, "postshortly migrate")
+
+	require.NoError(t, checkSchema(s, true))
+	require.NoError(t, checkSchema(s, false))
+
+	// A newer build has migrated this database.
+	_, latest, err := s.SchemaVersion()
+	require.NoError(t, err)
+	_, err = s.(*sqliteStore).db.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, 'from the future', 0)", latest+1)
+	require.NoError(t, err)
+
+	err = checkSchema(s, true)
+	assert.ErrorContains(t, err, "newer than the latest version")
+
+	_, err = s.Migrate()
+	assert.Error(t, err)
+}
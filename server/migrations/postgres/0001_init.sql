@@ -0,0 +1,30 @@
+-- Status Updates table
+CREATE TABLE IF NOT EXISTS status_updates (
+	id BIGSERIAL PRIMARY KEY,
+	timestamp BIGINT NOT NULL,
+	body TEXT NOT NULL,
+	link TEXT,
+	pubkey TEXT NOT NULL CHECK(length(pubkey) = 64),
+	signature TEXT NOT NULL CHECK(length(signature) = 128)
+);
+
+-- Statistics table
+CREATE TABLE IF NOT EXISTS statistics (
+	id BIGSERIAL PRIMARY KEY,
+	timestamp BIGINT NOT NULL,
+	total_posts INTEGER NOT NULL,
+	unique_pubkeys INTEGER NOT NULL,
+	successful_requests INTEGER NOT NULL,
+	failed_requests INTEGER NOT NULL,
+	total_requests INTEGER NOT NULL,
+	average_posts_per_pubkey DOUBLE PRECISION NOT NULL,
+	most_recent_post_timestamp BIGINT NOT NULL,
+	oldest_post_timestamp BIGINT NOT NULL,
+	rate_limit_requests_per_second INTEGER NOT NULL
+);
+
+-- Index for faster queries on pubkey
+CREATE INDEX IF NOT EXISTS idx_status_updates_pubkey ON status_updates(pubkey);
+
+-- Index for faster timestamp-based queries
+CREATE INDEX IF NOT EXISTS idx_status_updates_timestamp ON status_updates(timestamp);
@@ -0,0 +1,12 @@
+-- Instance a federated post was first published on; empty for local posts
+ALTER TABLE status_updates ADD COLUMN origin TEXT NOT NULL DEFAULT '';
+
+-- Position reached in each peer's feed by the sync worker
+CREATE TABLE peer_cursors (
+	peer TEXT PRIMARY KEY,
+	last_id BIGINT NOT NULL,
+	last_sync BIGINT NOT NULL
+);
+
+-- Index for duplicate checks when importing and syncing
+CREATE INDEX idx_status_updates_signature ON status_updates(signature);
@@ -0,0 +1,26 @@
+-- Webhook targets notified of new posts, optionally filtered by pubkey or tag
+CREATE TABLE webhooks (
+	id BIGSERIAL PRIMARY KEY,
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	pubkey TEXT NOT NULL DEFAULT '',
+	tag TEXT NOT NULL DEFAULT '',
+	created BIGINT NOT NULL
+);
+
+-- Webhook delivery queue and log
+CREATE TABLE webhook_deliveries (
+	id BIGSERIAL PRIMARY KEY,
+	webhook_id BIGINT NOT NULL,
+	post_id BIGINT NOT NULL,
+	payload TEXT NOT NULL,
+	status TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt BIGINT NOT NULL,
+	response_code INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT NOT NULL DEFAULT '',
+	created BIGINT NOT NULL,
+	updated BIGINT NOT NULL
+);
+
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt);
@@ -0,0 +1,19 @@
+-- Retried POSTs used to store the same signed post more than once. Keep the
+-- first copy of each signature before making signatures unique.
+DELETE FROM status_updates WHERE id NOT IN (
+	SELECT MIN(id) FROM status_updates GROUP BY signature
+);
+
+DROP INDEX idx_status_updates_signature;
+CREATE UNIQUE INDEX idx_status_updates_signature ON status_updates(signature);
+
+-- Idempotency-Key headers seen on POST /status, scoped to the posting key
+CREATE TABLE idempotency_keys (
+	pubkey TEXT NOT NULL,
+	idempotency_key TEXT NOT NULL,
+	status_id BIGINT NOT NULL,
+	created BIGINT NOT NULL,
+	PRIMARY KEY (pubkey, idempotency_key)
+);
+
+CREATE INDEX idx_idempotency_keys_created ON idempotency_keys(created);
@@ -0,0 +1,34 @@
+-- Posts hidden by a moderator. They stay stored but are left out of feeds.
+CREATE TABLE hidden_status_updates (
+	status_id BIGINT PRIMARY KEY,
+	reason TEXT NOT NULL,
+	created BIGINT NOT NULL
+);
+
+-- Pubkeys banned by a moderator. Their posts are refused and left out of
+-- feeds.
+CREATE TABLE banned_pubkeys (
+	pubkey TEXT PRIMARY KEY,
+	reason TEXT NOT NULL,
+	created BIGINT NOT NULL
+);
+
+-- Every moderation action, for the audit log
+CREATE TABLE moderation_log (
+	id BIGSERIAL PRIMARY KEY,
+	created BIGINT NOT NULL,
+	actor TEXT NOT NULL,
+	action TEXT NOT NULL,
+	target TEXT NOT NULL,
+	reason TEXT NOT NULL
+);
+
+-- The most recent posts refused by POST /status
+CREATE TABLE rejections (
+	id BIGSERIAL PRIMARY KEY,
+	created BIGINT NOT NULL,
+	pubkey TEXT NOT NULL,
+	code TEXT NOT NULL,
+	message TEXT NOT NULL,
+	remote_addr TEXT NOT NULL
+);
@@ -0,0 +1,7 @@
+-- Pubkeys allowed to post, and in private mode to read, on instances that
+-- restrict access
+CREATE TABLE allowed_pubkeys (
+	pubkey TEXT PRIMARY KEY,
+	reason TEXT NOT NULL,
+	created BIGINT NOT NULL
+);
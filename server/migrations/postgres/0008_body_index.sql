@@ -0,0 +1,2 @@
+-- Index for the duplicate body filter
+CREATE INDEX idx_status_updates_body ON status_updates(body);
@@ -0,0 +1,8 @@
+-- Direct messages, sealed to their recipient. The server cannot read them.
+CREATE TABLE messages (
+	id BIGSERIAL PRIMARY KEY,
+	recipient TEXT NOT NULL,
+	ciphertext BYTEA NOT NULL,
+	created BIGINT NOT NULL
+);
+CREATE INDEX idx_messages_recipient ON messages(recipient, id);
@@ -0,0 +1,16 @@
+-- Files uploaded with POST /media. The bytes live in the media directory,
+-- named by their SHA-256.
+CREATE TABLE media (
+	hash TEXT PRIMARY KEY,
+	mime_type TEXT NOT NULL,
+	size BIGINT NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	thumbnail BOOLEAN NOT NULL DEFAULT FALSE,
+	pubkey TEXT NOT NULL,
+	created BIGINT NOT NULL
+);
+
+-- Space separated hashes of the media a post carries
+ALTER TABLE status_updates ADD COLUMN attachments TEXT NOT NULL DEFAULT '';
+ALTER TABLE quarantined_updates ADD COLUMN attachments TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,10 @@
+-- Bans used to be stored as typed, so a ban in uppercase hex never matched
+-- the lowercase pubkeys posts are stored with.
+INSERT INTO banned_pubkeys (pubkey, reason, created)
+	SELECT lower(pubkey), MIN(reason), MIN(created) FROM banned_pubkeys
+	WHERE pubkey <> lower(pubkey) AND lower(pubkey) NOT IN (SELECT pubkey FROM banned_pubkeys)
+	GROUP BY lower(pubkey);
+DELETE FROM banned_pubkeys WHERE pubkey <> lower(pubkey);
+
+-- Webhooks filtering on an uppercase pubkey never fired.
+UPDATE webhooks SET pubkey = lower(pubkey) WHERE pubkey <> lower(pubkey);
@@ -0,0 +1,7 @@
+-- Allowed pubkeys used to be stored as typed, so a key allowed in
+-- uppercase hex never matched.
+INSERT INTO allowed_pubkeys (pubkey, reason, created)
+	SELECT lower(pubkey), MIN(reason), MIN(created) FROM allowed_pubkeys
+	WHERE pubkey <> lower(pubkey) AND lower(pubkey) NOT IN (SELECT pubkey FROM allowed_pubkeys)
+	GROUP BY lower(pubkey);
+DELETE FROM allowed_pubkeys WHERE pubkey <> lower(pubkey);
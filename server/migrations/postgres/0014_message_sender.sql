@@ -0,0 +1,3 @@
+-- A hash of each message's sender, so one sender cannot fill a mailbox.
+ALTER TABLE messages ADD COLUMN sender_hash TEXT NOT NULL DEFAULT '';
+CREATE INDEX idx_messages_sender ON messages(recipient, sender_hash);
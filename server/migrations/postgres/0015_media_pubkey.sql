@@ -0,0 +1,2 @@
+-- Uploads are counted per key against the media quota.
+CREATE INDEX idx_media_pubkey ON media(pubkey);
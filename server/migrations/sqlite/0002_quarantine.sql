@@ -0,0 +1,11 @@
+-- Rows moved out of status_updates by 'postshortly verify -quarantine'
+CREATE TABLE quarantined_updates (
+	id INTEGER PRIMARY KEY,
+	timestamp INTEGER NOT NULL,
+	body TEXT NOT NULL,
+	link TEXT,
+	pubkey TEXT NOT NULL,
+	signature TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	quarantined_at INTEGER NOT NULL
+);
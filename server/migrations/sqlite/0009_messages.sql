@@ -0,0 +1,8 @@
+-- Direct messages, sealed to their recipient. The server cannot read them.
+CREATE TABLE messages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	recipient TEXT NOT NULL,
+	ciphertext BLOB NOT NULL,
+	created INTEGER NOT NULL
+);
+CREATE INDEX idx_messages_recipient ON messages(recipient, id);
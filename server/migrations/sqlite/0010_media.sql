@@ -0,0 +1,16 @@
+-- Files uploaded with POST /media. The bytes live in the media directory,
+-- named by their SHA-256.
+CREATE TABLE media (
+	hash TEXT PRIMARY KEY,
+	mime_type TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	thumbnail INTEGER NOT NULL DEFAULT 0,
+	pubkey TEXT NOT NULL,
+	created INTEGER NOT NULL
+);
+
+-- Space separated hashes of the media a post carries
+ALTER TABLE status_updates ADD COLUMN attachments TEXT NOT NULL DEFAULT '';
+ALTER TABLE quarantined_updates ADD COLUMN attachments TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,12 @@
+-- Hex used to be stored as sent, so the same post could be stored a second
+-- time with its signature in another case. Keep the first copy of each
+-- post and store every pubkey and signature in lowercase.
+DELETE FROM status_updates WHERE id NOT IN (
+	SELECT MIN(id) FROM status_updates GROUP BY lower(signature)
+);
+UPDATE status_updates SET pubkey = lower(pubkey), signature = lower(signature)
+	WHERE pubkey <> lower(pubkey) OR signature <> lower(signature);
+
+-- Keys recorded for uppercase pubkeys can no longer match; they expire
+-- within a day anyway.
+DELETE FROM idempotency_keys WHERE pubkey <> lower(pubkey);
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Moderation actions recorded in the moderation log.
+const (
+	ActionHide   = "hide"
+	ActionUnhide = "unhide"
+	ActionBan    = "ban"
+	ActionUnban  = "unban"
+	// Allow and disallow edit the allow list of access.go.
+	ActionAllow    = "allow"
+	ActionDisallow = "disallow"
+)
+
+const (
+	// RejectionsKept is how many refused posts are remembered.
+	RejectionsKept = 1000
+	// ModerationListSize is the default number of entries returned by the
+	// moderation log and rejection endpoints.
+	ModerationListSize = 100
+	// ModerationRequestMaxSize bounds the body of a moderation request.
+	ModerationRequestMaxSize = 4 * 1024
+)
+
+// ModerationAction is an entry in the moderation log. Target is a post id
+// for hide and unhide, and a pubkey for the other actions.
+type ModerationAction struct {
+	ID      int    `json:"id"`
+	Created int64  `json:"created"`
+	Actor   string `json:"actor"`
+	Action  string `json:"action"`
+	Target  string `json:"target"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Ban is a pubkey whose posts are refused and left out of feeds.
+type Ban struct {
+	Pubkey  string `json:"pubkey"`
+	Reason  string `json:"reason,omitempty"`
+	Created int64  `json:"created"`
+}
+
+// AllowedPubkey is a pubkey on the allow list kept in the database.
+type AllowedPubkey struct {
+	Pubkey  string `json:"pubkey"`
+	Reason  string `json:"reason,omitempty"`
+	Created int64  `json:"created"`
+}
+
+// Rejection is a post refused by POST /status.
+type Rejection struct {
+	ID         int    `json:"id"`
+	Created    int64  `json:"created"`
+	Pubkey     string `json:"pubkey"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	RemoteAddr string `json:"remote_addr" db:"remote_addr"`
+}
+
+type moderationRequest struct {
+	Reason string `json:"reason"`
+}
+
+// rejectStatusUpdate answers a refused POST /status and remembers it for
+// GET /admin/rejections.
+func (s *Server) rejectStatusUpdate(w http.ResponseWriter, r *http.Request, update StatusUpdate, err error, statusCode int) {
+	resp := ErrorResponse{Code: CodeInvalidPayload, Message: err.Error()}
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		resp = ErrorResponse{Code: verr.Code, Message: verr.Message, Field: verr.Field}
+	}
+
+	pubkey := update.Pubkey
+	if len(pubkey) > PubkeyMaxSize*2 {
+		pubkey = pubkey[:PubkeyMaxSize*2]
+	}
+	rejection := Rejection{Pubkey: pubkey, Code: resp.Code, Message: resp.Message, RemoteAddr: r.RemoteAddr}
+	if err := s.store.AddRejection(&rejection); err != nil {
+		s.log.ErrorContext(r.Context(), "error recording rejection", "error", err)
+	}
+
+	s.writeError(w, r, resp, statusCode)
+}
+
+func (s *Server) hideStatusUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	s.moderate(w, r, ActionHide)
+}
+
+func (s *Server) unhideStatusUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	s.moderate(w, r, ActionUnhide)
+}
+
+func (s *Server) banHandler(w http.ResponseWriter, r *http.Request) {
+	s.moderate(w, r, ActionBan)
+}
+
+func (s *Server) unbanHandler(w http.ResponseWriter, r *http.Request) {
+	s.moderate(w, r, ActionUnban)
+}
+
+func (s *Server) allowHandler(w http.ResponseWriter, r *http.Request) {
+	s.moderate(w, r, ActionAllow)
+}
+
+func (s *Server) disallowHandler(w http.ResponseWriter, r *http.Request) {
+	s.moderate(w, r, ActionDisallow)
+}
+
+// moderate applies a moderation action to the post or pubkey in the path
+// and answers with the moderation log entry.
+func (s *Server) moderate(w http.ResponseWriter, r *http.Request, action string) {
+	entry := ModerationAction{Actor: adminActor(r), Action: action}
+
+	switch action {
+	case ActionHide, ActionUnhide:
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			s.writeError(w, r, ErrorResponse{Code: CodeInvalidParameter, Message: "Invalid status update id", Field: "id"}, http.StatusBadRequest)
+			return
+		}
+		entry.Target = strconv.Itoa(id)
+	case ActionBan, ActionUnban, ActionAllow, ActionDisallow:
+		// Posts are stored with lowercase hex, so bans must be too.
+		pubkey := strings.ToLower(mux.Vars(r)["pubkey"])
+		if _, err := hex.DecodeString(pubkey); err != nil || len(pubkey) != PubkeyMaxSize*2 {
+			s.writeError(w, r, ErrorResponse{Code: CodeInvalidPubkey, Message: "Invalid public key", Field: "pubkey"}, http.StatusBadRequest)
+			return
+		}
+		entry.Target = pubkey
+	}
+
+	if r.ContentLength != 0 {
+		var req moderationRequest
+		if !s.decodeJSON(w, r, &req, ModerationRequestMaxSize) {
+			return
+		}
+		entry.Reason = req.Reason
+	}
+
+	err := s.store.Moderate(&entry)
+	if err == sql.ErrNoRows {
+		s.handleError(w, r, CodeNotFound, notModeratedMessage(action), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.handleError(w, r, CodeInternal, "Error applying moderation action", http.StatusInternalServerError)
+		return
+	}
+
+	s.log.InfoContext(r.Context(), "moderation action", "actor", entry.Actor, "action", entry.Action, "target", entry.Target)
+	s.render(w, r, http.StatusOK, entry)
+}
+
+func notModeratedMessage(action string) string {
+	switch action {
+	case ActionHide:
+		return "Status update not found"
+	case ActionUnhide:
+		return "Status update is not hidden"
+	case ActionDisallow:
+		return "Pubkey is not on the allow list"
+	}
+	return "Pubkey is not banned"
+}
+
+func (s *Server) getBansHandler(w http.ResponseWriter, r *http.Request) {
+	bans, err := s.store.GetBans()
+	if err != nil {
+		s.handleError(w, r, CodeInternal, "Error retrieving bans", http.StatusInternalServerError)
+		return
+	}
+	s.render(w, r, http.StatusOK, bans)
+}
+
+func (s *Server) getAllowedPubkeysHandler(w http.ResponseWriter, r *http.Request) {
+	allowed, err := s.store.GetAllowedPubkeys()
+	if err != nil {
+		s.handleError(w, r, CodeInternal, "Error retrieving allowed pubkeys", http.StatusInternalServerError)
+		return
+	}
+	s.render(w, r, http.StatusOK, allowed)
+}
+
+func (s *Server) getModerationLogHandler(w http.ResponseWriter, r *http.Request) {
+	limit, ok := s.listLimit(w, r, ModerationListSize, RejectionsKept)
+	if !ok {
+		return
+	}
+	actions, err := s.store.GetModerationLog(limit)
+	if err != nil {
+		s.handleError(w, r, CodeInternal, "Error retrieving moderation log", http.StatusInternalServerError)
+		return
+	}
+	s.render(w, r, http.StatusOK, actions)
+}
+
+func (s *Server) getRejectionsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, ok := s.listLimit(w, r, ModerationListSize, RejectionsKept)
+	if !ok {
+		return
+	}
+	rejections, err := s.store.GetRejections(limit)
+	if err != nil {
+		s.handleError(w, r, CodeInternal, "Error retrieving rejections", http.StatusInternalServerError)
+		return
+	}
+	s.render(w, r, http.StatusOK, rejections)
+}
+
+// listLimit reads the optional limit parameter of a list, which defaults
+// to size and may be at most maximum.
+func (s *Server) listLimit(w http.ResponseWriter, r *http.Request, size, maximum int) (int, bool) {
+	if !r.URL.Query().Has("limit") {
+		return size, true
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit < 1 || limit > maximum {
+		s.writeError(w, r, ErrorResponse{
+			Code:    CodeInvalidParameter,
+			Message: fmt.Sprintf("limit must be between 1 and %d", maximum),
+			Field:   "limit",
+		}, http.StatusBadRequest)
+		return 0, false
+	}
+	return limit, true
+}
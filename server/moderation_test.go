@@ -0,0 +1,234 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/donuts-are-good/postshortly/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func postStatusUpdate(t *testing.T, handler http.Handler, update sdk.StatusUpdate) *httptest.ResponseRecorder {
+	t.Helper()
+	body, _ := json.Marshal(update)
+	req := httptest.NewRequest("POST", "/status", bytes.NewReader(body))
+	req.RemoteAddr = "192.0.2.1:1234"
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func feedLength(t *testing.T, handler http.Handler) int {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/status", nil))
+	var updates []StatusUpdate
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&updates))
+	return len(updates)
+}
+
+func TestModerationAPI(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	srv.limiter = rate.NewLimiter(rate.Inf, 1)
+	srv.config.AdminToken = testAdminToken
+	handler := srv.Handler()
+
+	_, privkey, _ := sdk.GenerateKey()
+	post := sdk.StatusUpdate{Body: "buy now"}
+	sdk.SignStatusUpdate(privkey, &post)
+	rr := postStatusUpdate(t, handler, post)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var created StatusUpdate
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&created))
+
+	// Hide the post with a reason.
+	path := fmt.Sprintf("/admin/status/%d/hide", created.ID)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, adminRequest("POST", path, strings.NewReader(`{"reason":"spam"}`)))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var action ModerationAction
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&action))
+	assert.Equal(t, ModerationAction{ID: action.ID, Created: action.Created, Actor: "token", Action: ActionHide, Target: strconv.Itoa(created.ID), Reason: "spam"}, action)
+	assert.Zero(t, feedLength(t, handler))
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, adminRequest("DELETE", path, nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 1, feedLength(t, handler))
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, adminRequest("DELETE", path, nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, adminRequest("POST", "/admin/status/999999/hide", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	// Ban the author: the post disappears and new posts are refused.
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, adminRequest("PUT", "/admin/bans/"+post.Pubkey, nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Zero(t, feedLength(t, handler))
+
+	again := sdk.StatusUpdate{Body: "buy again"}
+	sdk.SignStatusUpdate(privkey, &again)
+	rr = postStatusUpdate(t, handler, again)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	var errResp ErrorResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&errResp))
+	assert.Equal(t, CodePubkeyBanned, errResp.Code)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, adminRequest("GET", "/admin/bans", nil))
+	var bans []Ban
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&bans))
+	require.Len(t, bans, 1)
+	assert.Equal(t, post.Pubkey, bans[0].Pubkey)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, adminRequest("GET", "/admin/rejections", nil))
+	var rejections []Rejection
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&rejections))
+	require.Len(t, rejections, 1)
+	assert.Equal(t, CodePubkeyBanned, rejections[0].Code)
+	assert.Equal(t, post.Pubkey, rejections[0].Pubkey)
+	assert.Equal(t, "192.0.2.1:1234", rejections[0].RemoteAddr)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, adminRequest("GET", "/admin/log?limit=2", nil))
+	var log []ModerationAction
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&log))
+	require.Len(t, log, 2)
+	assert.Equal(t, ActionBan, log[0].Action)
+	assert.Equal(t, ActionUnhide, log[1].Action)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, adminRequest("PUT", "/admin/bans/nothex", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestRejectionsRecorded(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	srv.limiter = rate.NewLimiter(rate.Inf, 1)
+
+	_, privkey, _ := sdk.GenerateKey()
+	post := sdk.StatusUpdate{Body: "hello"}
+	sdk.SignStatusUpdate(privkey, &post)
+	post.Body = "tampered"
+	rr := postStatusUpdate(t, srv.Handler(), post)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rejections, err := srv.store.GetRejections(10)
+	require.NoError(t, err)
+	require.Len(t, rejections, 1)
+	assert.Equal(t, CodeSignatureMismatch, rejections[0].Code)
+}
+
+func TestOperatorSignedRequests(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	pub, priv, _ := sdk.GenerateKey()
+	srv.config.OperatorKeys = []string{hex.EncodeToString(pub)}
+	handler := srv.Handler()
+
+	post := testPost(strings.Repeat("a", PubkeyMaxSize*2), "post")
+	require.NoError(t, srv.store.AddStatusUpdate(&post))
+	path := fmt.Sprintf("/admin/status/%d/hide", post.ID)
+
+	signed := func(body string) *http.Request {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		require.NoError(t, sdk.SignRequest(req, priv))
+		return req
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, signed(`{"reason":"signed"}`))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var action ModerationAction
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&action))
+	assert.Equal(t, "operator:"+hex.EncodeToString(pub), action.Actor)
+	assert.Equal(t, "signed", action.Reason)
+
+	// The token is not configured, so it cannot be used instead.
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, adminRequest("GET", "/admin/log", nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	tests := []struct {
+		name   string
+		tamper func(req *http.Request)
+	}{
+		{"other body", func(req *http.Request) {
+			req.Body = httptest.NewRequest("POST", path, strings.NewReader(`{"reason":"changed"}`)).Body
+		}},
+		{"other path", func(req *http.Request) { req.URL.Path = "/admin/status/999/hide" }},
+		{"unknown key", func(req *http.Request) {
+			other, _, _ := sdk.GenerateKey()
+			req.Header.Set(sdk.RequestKeyHeader, hex.EncodeToString(other))
+		}},
+		{"old timestamp", func(req *http.Request) {
+			req.Header.Set(sdk.RequestTimestampHeader, strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+		}},
+		{"bad signature", func(req *http.Request) { req.Header.Set(sdk.RequestSignatureHeader, "00") }},
+		{"other nonce", func(req *http.Request) { req.Header.Set(sdk.RequestNonceHeader, "abc") }},
+		{"no nonce", func(req *http.Request) { req.Header.Del(sdk.RequestNonceHeader) }},
+	}
+	for _, tt := range tests {
+		req := signed(`{"reason":"signed"}`)
+		tt.tamper(req)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, tt.name)
+	}
+}
+
+func TestBanIgnoresHexCase(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	srv.limiter = rate.NewLimiter(rate.Inf, 1)
+	srv.config.AdminToken = testAdminToken
+	handler := srv.Handler()
+
+	// Banned in lowercase, posting in uppercase.
+	_, privkey, _ := sdk.GenerateKey()
+	post := sdk.StatusUpdate{Body: "buy now"}
+	sdk.SignStatusUpdate(privkey, &post)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, adminRequest("PUT", "/admin/bans/"+post.Pubkey, nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	post.Pubkey = strings.ToUpper(post.Pubkey)
+	rr = postStatusUpdate(t, handler, post)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	var errResp ErrorResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&errResp))
+	assert.Equal(t, CodePubkeyBanned, errResp.Code)
+	assert.Zero(t, feedLength(t, handler))
+
+	// Banned in uppercase, posting in lowercase.
+	_, other, _ := sdk.GenerateKey()
+	again := sdk.StatusUpdate{Body: "buy again"}
+	sdk.SignStatusUpdate(other, &again)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, adminRequest("PUT", "/admin/bans/"+strings.ToUpper(again.Pubkey), nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	banned, err := srv.store.IsPubkeyBanned(again.Pubkey)
+	require.NoError(t, err)
+	assert.True(t, banned)
+
+	rr = postStatusUpdate(t, handler, again)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Zero(t, feedLength(t, handler))
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/donuts-are-good/postshortly/sdk"
+)
+
+// ProofOfWorkConfig sets how much work a post must carry. Keys cost nothing
+// to make, so per-pubkey limits alone don't stop a flood of posts; a stamp
+// that takes a moment to compute does.
+type ProofOfWorkConfig struct {
+	// Difficulty is the number of leading zero bits the SHA-256 of a post's
+	// stamp must have. Zero turns proof of work off.
+	Difficulty int `json:"difficulty"`
+	// EstablishedDifficulty replaces Difficulty for pubkeys with at least
+	// EstablishedPosts visible posts, the first at least EstablishedAge
+	// old. With both thresholds zero every pubkey needs Difficulty.
+	EstablishedDifficulty int      `json:"established_difficulty"`
+	EstablishedPosts      int      `json:"established_posts"`
+	EstablishedAge        Duration `json:"established_age"`
+}
+
+// PubkeyHistory summarizes what a pubkey has posted.
+type PubkeyHistory struct {
+	Posts int `db:"posts"`
+	// FirstPost is the timestamp of the oldest post, zero without posts.
+	FirstPost int64 `db:"first_post"`
+}
+
+// proofOfWorkDifficulty returns the bits of work a post by pubkey needs.
+func (s *Server) proofOfWorkDifficulty(pubkey string) (int, error) {
+	cfg := s.config.ProofOfWork
+	if cfg.Difficulty == 0 || (cfg.EstablishedPosts == 0 && cfg.EstablishedAge == 0) {
+		return cfg.Difficulty, nil
+	}
+
+	history, err := s.store.GetPubkeyHistory(pubkey)
+	if err != nil {
+		return 0, fmt.Errorf("error reading pubkey history: %v", err)
+	}
+	if history.Posts == 0 || history.Posts < cfg.EstablishedPosts {
+		return cfg.Difficulty, nil
+	}
+	if time.Since(time.Unix(0, history.FirstPost)) < time.Duration(cfg.EstablishedAge) {
+		return cfg.Difficulty, nil
+	}
+	return min(cfg.EstablishedDifficulty, cfg.Difficulty), nil
+}
+
+func verifyProofOfWork(update StatusUpdate, difficulty int) error {
+	if len(update.Nonce) > NonceMaxSize {
+		return &ValidationError{Code: CodeInsufficientWork, Field: "nonce", Message: fmt.Sprintf("nonce exceeds maximum size of %d characters", NonceMaxSize)}
+	}
+	if difficulty == 0 {
+		return nil
+	}
+	if sdk.ProofOfWorkBits(toSDKStatusUpdate(update)) < difficulty {
+		return &ValidationError{Code: CodeInsufficientWork, Field: "nonce", Message: fmt.Sprintf("proof of work of %d bits required", difficulty)}
+	}
+	return nil
+}
+
+// getProofOfWorkHandler serves GET /pow?pubkey=<pubkey>, the difficulty a
+// post by pubkey must meet.
+func (s *Server) getProofOfWorkHandler(w http.ResponseWriter, r *http.Request) {
+	pubkey := strings.ToLower(r.URL.Query().Get("pubkey"))
+	if len(pubkey) > PubkeyMaxSize*2 {
+		s.writeError(w, r, ErrorResponse{Code: CodeInvalidPubkey, Message: "invalid pubkey length", Field: "pubkey"}, http.StatusBadRequest)
+		return
+	}
+
+	difficulty, err := s.proofOfWorkDifficulty(pubkey)
+	if err != nil {
+		s.handleError(w, r, CodeInternal, "Error checking pubkey", http.StatusInternalServerError)
+		return
+	}
+	s.render(w, r, http.StatusOK, sdk.ProofOfWork{Pubkey: pubkey, Difficulty: difficulty})
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/donuts-are-good/postshortly/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestProofOfWorkRequired(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	srv.limiter = rate.NewLimiter(rate.Inf, 1)
+	srv.config.ProofOfWork.Difficulty = 8
+	handler := srv.Handler()
+
+	_, priv, _ := sdk.GenerateKey()
+	post := sdk.StatusUpdate{Body: "hello"}
+	sdk.SignStatusUpdate(priv, &post)
+	// One nonce in 256 meets 8 bits by luck.
+	for i := 0; sdk.ProofOfWorkBits(post) >= 8; i++ {
+		post.Nonce = fmt.Sprint(i)
+	}
+
+	rr := postStatusUpdate(t, handler, post)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	var resp ErrorResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.Equal(t, CodeInsufficientWork, resp.Code)
+	assert.Equal(t, "nonce", resp.Field)
+
+	require.NoError(t, sdk.SolveProofOfWork(context.Background(), &post, 8))
+	rr = postStatusUpdate(t, handler, post)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var created StatusUpdate
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&created))
+	assert.Empty(t, created.Nonce, "the nonce is not stored")
+}
+
+func TestProofOfWorkCheckedBeforeSignature(t *testing.T) {
+	t.Parallel()
+	_, priv, _ := sdk.GenerateKey()
+	post := sdk.StatusUpdate{Body: "hello"}
+	sdk.SignStatusUpdate(priv, &post)
+	post.Body = "forged"
+	for i := 0; sdk.ProofOfWorkBits(post) >= 16; i++ {
+		post.Nonce = fmt.Sprint(i)
+	}
+
+	err := validateStatusUpdate(fromSDKStatusUpdate(post), 16)
+	var verr *ValidationError
+	require.ErrorAs(t, err, &verr)
+	assert.Equal(t, CodeInsufficientWork, verr.Code)
+}
+
+func TestProofOfWorkEstablishedPubkeys(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	srv.config.ProofOfWork = ProofOfWorkConfig{
+		Difficulty:            20,
+		EstablishedDifficulty: 4,
+		EstablishedPosts:      2,
+		EstablishedAge:        Duration(time.Hour),
+	}
+	handler := srv.Handler()
+
+	pub, _, _ := sdk.GenerateKey()
+	pubkey := hex.EncodeToString(pub)
+	difficulty := func() int {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/pow?pubkey="+pubkey, nil))
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var pow sdk.ProofOfWork
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&pow))
+		return pow.Difficulty
+	}
+
+	assert.Equal(t, 20, difficulty(), "new pubkey")
+
+	old := time.Now().Add(-2 * time.Hour).UnixNano()
+	first := testPost(pubkey, "first")
+	first.Timestamp = old
+	require.NoError(t, srv.store.AddStatusUpdate(&first))
+	assert.Equal(t, 20, difficulty(), "too few posts")
+
+	second := testPost(pubkey, "second")
+	require.NoError(t, srv.store.AddStatusUpdate(&second))
+	assert.Equal(t, 4, difficulty(), "established pubkey")
+
+	require.NoError(t, srv.store.Moderate(&ModerationAction{Actor: "token", Action: ActionHide, Target: fmt.Sprint(second.ID)}))
+	assert.Equal(t, 20, difficulty(), "hidden posts don't count")
+}
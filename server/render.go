@@ -0,0 +1,201 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Media types the API speaks. Requests are always JSON; responses can also
+// be CBOR, and feeds can be streamed as newline-delimited JSON.
+const (
+	mediaJSON   = "application/json"
+	mediaNDJSON = "application/x-ndjson"
+	mediaCBOR   = "application/cbor"
+
+	contentTypeJSON   = mediaJSON + "; charset=utf-8"
+	contentTypeNDJSON = mediaNDJSON + "; charset=utf-8"
+)
+
+// ndjsonFlushEvery is how many lines of a streamed feed are buffered before
+// they are flushed to the client.
+const ndjsonFlushEvery = 100
+
+// render writes v with the given status in the format the client asked for
+// in its Accept header, JSON unless it prefers CBOR.
+func (s *Server) render(w http.ResponseWriter, r *http.Request, statusCode int, v any) {
+	mediaType, ok := negotiate(r, mediaJSON, mediaCBOR)
+	if !ok {
+		s.notAcceptable(w, r, mediaJSON, mediaCBOR)
+		return
+	}
+
+	var body []byte
+	var err error
+	if mediaType == mediaCBOR {
+		body, err = marshalCBOR(v)
+	} else {
+		body, err = json.Marshal(v)
+		body = append(body, '\n')
+	}
+	if err != nil {
+		s.handleError(w, r, CodeInternal, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType(mediaType))
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
+
+// renderFeed writes the posts produced by each, either as one document like
+// render or, for clients accepting application/x-ndjson, as a stream of one
+// post per line that never holds the whole feed in memory.
+func (s *Server) renderFeed(w http.ResponseWriter, r *http.Request, each func(fn func(StatusUpdate) error) error) {
+	mediaType, ok := negotiate(r, mediaJSON, mediaNDJSON, mediaCBOR)
+	if !ok {
+		s.notAcceptable(w, r, mediaJSON, mediaNDJSON, mediaCBOR)
+		return
+	}
+
+	if mediaType != mediaNDJSON {
+		updates := []StatusUpdate{}
+		err := each(func(update StatusUpdate) error {
+			updates = append(updates, update)
+			return nil
+		})
+		if err != nil {
+			s.handleError(w, r, CodeInternal, "Error retrieving status updates", http.StatusInternalServerError)
+			return
+		}
+		s.render(w, r, http.StatusOK, updates)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeNDJSON)
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(http.StatusOK)
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	flusher, _ := w.(http.Flusher)
+	lines := 0
+	err := each(func(update StatusUpdate) error {
+		if err := enc.Encode(update); err != nil {
+			return err
+		}
+		lines++
+		if lines%ndjsonFlushEvery == 0 {
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return nil
+	})
+	// The status line is already sent, so a failure midway can only cut the
+	// stream short.
+	if err != nil {
+		s.metrics.failedRequests.Add(1)
+		return
+	}
+	bw.Flush()
+}
+
+func (s *Server) notAcceptable(w http.ResponseWriter, r *http.Request, offers ...string) {
+	s.handleError(w, r, CodeNotAcceptable, "Acceptable media types are "+strings.Join(offers, ", "), http.StatusNotAcceptable)
+}
+
+func contentType(mediaType string) string {
+	switch mediaType {
+	case mediaJSON:
+		return contentTypeJSON
+	case mediaNDJSON:
+		return contentTypeNDJSON
+	}
+	return mediaType
+}
+
+// negotiate picks the offer the Accept header of r ranks highest. Ties go
+// to the earlier offer, and a request without Accept gets the first one.
+func negotiate(r *http.Request, offers ...string) (string, bool) {
+	accept := r.Header.Values("Accept")
+	if len(accept) == 0 {
+		return offers[0], true
+	}
+
+	type rangeQ struct {
+		mediaRange string
+		q          float64
+	}
+	var ranges []rangeQ
+	for _, header := range accept {
+		for _, part := range strings.Split(header, ",") {
+			mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			q := 1.0
+			if v, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(v, 64); err != nil {
+					continue
+				}
+			}
+			ranges = append(ranges, rangeQ{mediaRange, q})
+		}
+	}
+	// More specific ranges take precedence over wildcards.
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return strings.Count(ranges[i].mediaRange, "*") < strings.Count(ranges[j].mediaRange, "*")
+	})
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		for _, rq := range ranges {
+			if !mediaRangeMatches(rq.mediaRange, offer) {
+				continue
+			}
+			if rq.q > bestQ {
+				best, bestQ = offer, rq.q
+			}
+			break
+		}
+	}
+	return best, best != ""
+}
+
+func mediaRangeMatches(mediaRange, mediaType string) bool {
+	if mediaRange == "*/*" || mediaRange == mediaType {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(mediaRange, "/*")
+	return ok && strings.HasPrefix(mediaType, prefix+"/")
+}
+
+// requireJSON answers 415 unless the request body is declared as JSON. A
+// missing Content-Type is taken to be JSON for older clients.
+func (s *Server) requireJSON(w http.ResponseWriter, r *http.Request) bool {
+	header := r.Header.Get("Content-Type")
+	if header == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err == nil && (mediaType == mediaJSON || strings.HasSuffix(mediaType, "+json")) {
+		return true
+	}
+
+	s.writeError(w, r, ErrorResponse{
+		Code:    CodeUnsupportedMediaType,
+		Message: "Request body must be " + mediaJSON,
+		Field:   "Content-Type",
+	}, http.StatusUnsupportedMediaType)
+	return false
+}
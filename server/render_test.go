@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{mediaJSON, mediaNDJSON, mediaCBOR}
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", mediaJSON},
+		{"*/*", mediaJSON},
+		{"application/*", mediaJSON},
+		{"application/x-ndjson", mediaNDJSON},
+		{"application/cbor, application/json;q=0.5", mediaCBOR},
+		{"application/json;q=0.5, application/x-ndjson", mediaNDJSON},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", mediaJSON},
+		{"application/*;q=0.2, application/cbor", mediaCBOR},
+		{"application/json;q=0, */*", mediaNDJSON},
+		{"text/html", ""},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "/status", nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		got, ok := negotiate(req, offers...)
+		assert.Equal(t, tt.want, got, tt.accept)
+		assert.Equal(t, tt.want != "", ok, tt.accept)
+	}
+}
+
+func TestMarshalCBOR(t *testing.T) {
+	// Examples from RFC 8949 appendix A.
+	tests := []struct {
+		value any
+		want  string
+	}{
+		{0, "00"},
+		{24, "1818"},
+		{1000000, "1a000f4240"},
+		{int64(-1), "20"},
+		{-1000, "3903e7"},
+		{1.5, "fb3ff8000000000000"},
+		{true, "f5"},
+		{nil, "f6"},
+		{"IETF", "6449455446"},
+		{[]int{1, 2, 3}, "83010203"},
+		{map[string]int{"b": 2, "a": 1}, "a2616101616202"},
+		{struct {
+			A int    `json:"a"`
+			B string `json:"b,omitempty"`
+			C string `json:"-"`
+		}{A: 1, C: "x"}, "a1616101"},
+	}
+	for _, tt := range tests {
+		got, err := marshalCBOR(tt.value)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, hex.EncodeToString(got), "%v", tt.value)
+	}
+}
+
+func TestFeedRepresentations(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	addSignedPosts(t, srv, 3)
+	handler := srv.Handler()
+
+	get := func(accept string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/status", nil)
+		req.Header.Set("Accept", accept)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get(mediaJSON)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, contentTypeJSON, rr.Header().Get("Content-Type"))
+	var updates []StatusUpdate
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&updates))
+	assert.Len(t, updates, 3)
+
+	rr = get(mediaNDJSON)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, contentTypeNDJSON, rr.Header().Get("Content-Type"))
+	scanner := bufio.NewScanner(rr.Body)
+	var lines []StatusUpdate
+	for scanner.Scan() {
+		var update StatusUpdate
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &update))
+		lines = append(lines, update)
+	}
+	assert.Equal(t, updates, lines)
+
+	rr = get(mediaCBOR)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, mediaCBOR, rr.Header().Get("Content-Type"))
+	want, err := marshalCBOR(updates)
+	require.NoError(t, err)
+	assert.Equal(t, want, rr.Body.Bytes())
+
+	rr = get("text/html")
+	assert.Equal(t, http.StatusNotAcceptable, rr.Code)
+}
+
+func TestCreateStatusUpdateRequiresJSON(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	srv.limiter = rate.NewLimiter(rate.Inf, 1)
+
+	for contentType, want := range map[string]int{
+		"application/x-www-form-urlencoded": http.StatusUnsupportedMediaType,
+		"text/plain":                        http.StatusUnsupportedMediaType,
+		"application/json; charset=utf-8":   http.StatusBadRequest,
+		"":                                  http.StatusBadRequest,
+	} {
+		req, _ := http.NewRequest("POST", "/status", bytes.NewBufferString("body=hello"))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rr := httptest.NewRecorder()
+		srv.setupRouter().ServeHTTP(rr, req)
+		assert.Equal(t, want, rr.Code, contentType)
+	}
+}
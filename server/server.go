@@ -0,0 +1,143 @@
+// Package server implements the postshortly HTTP API. A Server can be run
+// by the postshortly binary or embedded in another Go program:
+//
+//	store, err := server.OpenStore("postshortly.sqlite.db", true)
+//	...
+//	srv, err := server.New(server.DefaultConfig(), store)
+//	...
+//	srv.Start(ctx)
+//	http.ListenAndServe(":3495", srv.Handler())
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	BodyMaxSize          = 256
+	LinkMaxSize          = 256
+	PubkeyMaxSize        = ed25519.PublicKeySize
+	SignatureMaxSize     = ed25519.SignatureSize
+	StatsRefreshInterval = 500 * time.Millisecond
+	Port                 = 3495
+	FeedPageSize         = 100
+	FeedMaxPageSize      = 1000
+
+	// IdempotencyKeyHeader lets a client retry POST /status safely: a
+	// repeated key returns the post the first request created.
+	IdempotencyKeyHeader  = "Idempotency-Key"
+	IdempotencyKeyMaxSize = 255
+	IdempotencyKeyTTL     = 24 * time.Hour
+
+	NonceMaxSize = 64
+
+	// AttachmentsMax is how many media hashes a post may carry.
+	AttachmentsMax = 4
+)
+
+type StatusUpdate struct {
+	ID        int    `json:"id"`
+	Timestamp int64  `json:"timestamp"`
+	Body      string `json:"body"`
+	Link      string `json:"link,omitempty"`
+	Pubkey    string `json:"pubkey"`
+	Signature string `json:"signature"`
+	Origin    string `json:"origin,omitempty"`
+	// Attachments are the hashes of media uploaded with POST /media. They
+	// are covered by the signature.
+	Attachments Hashes `json:"attachments,omitempty"`
+	// Nonce is the proof of work sent with a post. It is checked on
+	// arrival and not stored.
+	Nonce string `json:"nonce,omitempty" db:"-"`
+}
+
+// Server holds everything a running instance needs. Servers share nothing,
+// so several can run in one process.
+type Server struct {
+	config  Config
+	store   Store
+	limiter *rate.Limiter
+	// messageSenders and messageRecipients limit direct messages per
+	// key, apart from limiter.
+	messageSenders    keyLimiter
+	messageRecipients keyLimiter
+	// mediaUploaders limits uploads per key.
+	mediaUploaders keyLimiter
+	metrics        metrics
+
+	log       *slog.Logger
+	accessLog *slog.Logger
+
+	allowFile keyFile
+	denyFile  keyFile
+
+	filters []ContentFilter
+
+	seenNonces nonceCache
+
+	// migrated is set once the schema has been seen to be current.
+	migrated atomic.Bool
+}
+
+type metrics struct {
+	successfulRequests atomic.Int64
+	failedRequests     atomic.Int64
+}
+
+// New returns a Server backed by store. The caller keeps ownership of the
+// store and closes it once the server is done. It fails if the filter
+// config is invalid, which LoadConfig also checks.
+// Logs go to standard error and the access log to standard output until
+// SetLogOutput is called.
+func New(cfg Config, store Store) (*Server, error) {
+	s := &Server{
+		config:            cfg,
+		store:             store,
+		limiter:           rate.NewLimiter(1, 1),
+		messageSenders:    keyLimiter{limit: rate.Every(MessageSenderInterval), burst: MessageSenderBurst},
+		messageRecipients: keyLimiter{limit: rate.Every(MessageRecipientInterval), burst: MessageRecipientBurst},
+		mediaUploaders:    keyLimiter{limit: rate.Every(MediaUploadInterval), burst: MediaUploadBurst},
+		allowFile:         keyFile{path: cfg.Access.AllowFile},
+		denyFile:          keyFile{path: cfg.Access.DenyFile},
+	}
+	filters, err := cfg.Filters.compile(store)
+	if err != nil {
+		return nil, fmt.Errorf("error in filter config: %v", err)
+	}
+	s.filters = filters
+	s.SetLogOutput(os.Stderr, os.Stdout)
+	return s, nil
+}
+
+// Store returns the store the server was created with.
+func (s *Server) Store() Store {
+	return s.store
+}
+
+// Handler returns the HTTP API with request ids, the access log and the
+// CORS policy applied.
+func (s *Server) Handler() http.Handler {
+	r := s.setupRouter()
+	return requestIDMiddleware(s.accessLogMiddleware(newCORSPolicy(s.config.CORS, r).middleware(r)))
+}
+
+// Start launches the background workers: the statistics recorder, peer
+// sync, webhook delivery, scheduled backups and access list reloading. They
+// stop when ctx is cancelled.
+func (s *Server) Start(ctx context.Context) {
+	go s.recordStats(ctx)
+	go s.runAccessListReload(ctx)
+	go s.runPeerSync(ctx)
+	go s.runWebhookDeliveries(ctx)
+	go s.runBackups(ctx)
+	go s.runMediaCleanup(ctx)
+}
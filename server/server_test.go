@@ -1,4 +1,4 @@
-package main
+package server
 
 import (
 	"bytes"
@@ -6,42 +6,39 @@ import (
 	"crypto/ed25519"
 	"encoding/hex"
 	"encoding/json"
-	"io"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/donuts-are-good/postshortly/sdk"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/time/rate"
 )
 
-func setup() {
-	// Reset the global state before each test
-	successfulRequests = 0
-	failedRequests = 0
-	limiter = rate.NewLimiter(1, 1) // Reset to default rate limit
+// newTestServer returns a Server with the default config backed by its own
+// in-memory database, so tests don't share state and can run in parallel.
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
 
-	// Initialize the test database
-	if err := initDB(); err != nil {
-		panic(err)
+	store, err := OpenMemoryStore()
+	if err != nil {
+		t.Fatal(err)
 	}
-}
+	t.Cleanup(func() { store.Close() })
 
-func teardown() {
-	// Close the database connection
-	if db != nil {
-		db.Close()
+	srv, err := New(DefaultConfig(), store)
+	if err != nil {
+		t.Fatal(err)
 	}
-	// Remove the test database file
-	os.Remove(dbFile)
+	return srv
 }
 
 func TestCreateStatusUpdate(t *testing.T) {
-	setup()
-	defer teardown()
+	t.Parallel()
+	srv := newTestServer(t)
 
 	// Generate a key pair for testing
 	pubkey, privkey, _ := ed25519.GenerateKey(nil)
@@ -61,7 +58,7 @@ func TestCreateStatusUpdate(t *testing.T) {
 	assert.NoError(t, err)
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(createStatusUpdate)
+	handler := http.HandlerFunc(srv.createStatusUpdate)
 
 	handler.ServeHTTP(rr, req)
 
@@ -77,9 +74,130 @@ func TestCreateStatusUpdate(t *testing.T) {
 	assert.NotZero(t, response.Timestamp)
 }
 
+func TestCreateStatusUpdateSignedWithSDK(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+
+	_, privkey, _ := sdk.GenerateKey()
+	update := sdk.StatusUpdate{Body: "Test body", Link: "http://example.com"}
+	sdk.SignStatusUpdate(privkey, &update)
+
+	body, _ := json.Marshal(update)
+	req, err := http.NewRequest("POST", "/status", bytes.NewBuffer(body))
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(srv.createStatusUpdate).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestSDKRoundTripWithMarkup(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	srv.limiter = rate.NewLimiter(rate.Inf, 1)
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	client := sdk.NewClient(ts.URL)
+	ctx := context.Background()
+
+	_, privkey, _ := sdk.GenerateKey()
+	for _, body := range []string{"Tom & Jerry", "a < b", `she said "hi" <b>loudly</b>`} {
+		update := sdk.StatusUpdate{Body: body, Link: "http://example.com/?a=1&b=2"}
+		sdk.SignStatusUpdate(privkey, &update)
+		created, err := client.Post(ctx, update)
+		require.NoError(t, err, body)
+		assert.Equal(t, body, created.Body)
+
+		stored, err := srv.store.GetStatusUpdate(created.ID)
+		require.NoError(t, err)
+		assert.NoError(t, sdk.VerifyStatusUpdate(toSDKStatusUpdate(stored)), body)
+	}
+
+	report, err := AuditStatusUpdates(srv.store, false)
+	require.NoError(t, err)
+	assert.Empty(t, report.Failed)
+}
+
+func TestCreateStatusUpdateIdempotent(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	srv.limiter = rate.NewLimiter(rate.Inf, 1)
+
+	_, privkey, _ := sdk.GenerateKey()
+	post := func(body, key string) (*httptest.ResponseRecorder, StatusUpdate) {
+		update := sdk.StatusUpdate{Body: body}
+		sdk.SignStatusUpdate(privkey, &update)
+		payload, _ := json.Marshal(update)
+		req, _ := http.NewRequest("POST", "/status", bytes.NewBuffer(payload))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.createStatusUpdate).ServeHTTP(rr, req)
+
+		var response StatusUpdate
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response
+	}
+
+	// A plain retry of the same signed post returns the stored copy.
+	rr, first := post("Test body", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("Idempotent-Replayed"))
+	rr, retried := post("Test body", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "true", rr.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first, retried)
+
+	// So does a repeated Idempotency-Key, which cannot be reused for
+	// another post.
+	rr, keyed := post("Second body", "retry-1")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr, replayed := post("Second body", "retry-1")
+	assert.Equal(t, "true", rr.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, keyed, replayed)
+	rr, _ = post("Third body", "retry-1")
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	rr, _ = post("Fourth body", strings.Repeat("k", IdempotencyKeyMaxSize+1))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	updates, err := srv.store.GetAllStatusUpdates()
+	assert.NoError(t, err)
+	assert.Len(t, updates, 2)
+}
+
+func TestCreateStatusUpdateUppercaseHex(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	srv.limiter = rate.NewLimiter(rate.Inf, 1)
+	handler := srv.Handler()
+
+	_, privkey, _ := sdk.GenerateKey()
+	update := sdk.StatusUpdate{Body: "Test body"}
+	sdk.SignStatusUpdate(privkey, &update)
+	rr := postStatusUpdate(t, handler, update)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var first StatusUpdate
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&first))
+
+	// The same post with its hex in uppercase is a retry, not a new post.
+	update.Pubkey = strings.ToUpper(update.Pubkey)
+	update.Signature = strings.ToUpper(update.Signature)
+	rr = postStatusUpdate(t, handler, update)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "true", rr.Header().Get("Idempotent-Replayed"))
+	var again StatusUpdate
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&again))
+	assert.Equal(t, first, again)
+	assert.Equal(t, strings.ToLower(update.Signature), again.Signature)
+	assert.Equal(t, 1, feedLength(t, handler))
+}
+
 func TestCreateStatusUpdateRateLimit(t *testing.T) {
-	setup()
-	defer teardown()
+	t.Parallel()
+	srv := newTestServer(t)
 
 	// Generate a key pair for testing
 	pubkey, privkey, _ := ed25519.GenerateKey(nil)
@@ -96,31 +214,34 @@ func TestCreateStatusUpdateRateLimit(t *testing.T) {
 	body, _ := json.Marshal(update)
 
 	// Set a very low rate limit for testing
-	limiter = rate.NewLimiter(rate.Every(1*time.Second), 1)
+	srv.limiter = rate.NewLimiter(rate.Every(1*time.Second), 1)
 
 	// First request should pass
 	req, _ := http.NewRequest("POST", "/status", bytes.NewBuffer(body))
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(createStatusUpdate)
+	handler := http.HandlerFunc(srv.createStatusUpdate)
 	handler.ServeHTTP(rr, req)
 	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "0", rr.Header().Get("X-RateLimit-Remaining"))
 
 	// Second request should be rate limited
 	req, _ = http.NewRequest("POST", "/status", bytes.NewBuffer(body))
 	rr = httptest.NewRecorder()
 	handler.ServeHTTP(rr, req)
 	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
 }
 
 func TestCreateStatusUpdateInvalidPayload(t *testing.T) {
-	setup()
-	defer teardown()
+	t.Parallel()
+	srv := newTestServer(t)
 
 	req, err := http.NewRequest("POST", "/status", bytes.NewBuffer([]byte("invalid payload")))
 	assert.NoError(t, err)
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(createStatusUpdate)
+	handler := http.HandlerFunc(srv.createStatusUpdate)
 
 	handler.ServeHTTP(rr, req)
 
@@ -128,14 +249,14 @@ func TestCreateStatusUpdateInvalidPayload(t *testing.T) {
 }
 
 func TestGetStatusUpdatesByPubkeyInvalidKey(t *testing.T) {
-	setup()
-	defer teardown()
+	t.Parallel()
+	srv := newTestServer(t)
 
 	req, err := http.NewRequest("GET", "/status/invalidpubkey", nil)
 	assert.NoError(t, err)
 
 	rr := httptest.NewRecorder()
-	router := setupRouter()
+	router := srv.setupRouter()
 	router.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusBadRequest, rr.Code)
@@ -143,8 +264,8 @@ func TestGetStatusUpdatesByPubkeyInvalidKey(t *testing.T) {
 }
 
 func TestGetStatusUpdatesByPubkey(t *testing.T) {
-	setup()
-	defer teardown()
+	t.Parallel()
+	srv := newTestServer(t)
 
 	// Generate a key pair for testing
 	pubkey, privkey, _ := ed25519.GenerateKey(nil)
@@ -157,14 +278,14 @@ func TestGetStatusUpdatesByPubkey(t *testing.T) {
 		Pubkey:    pubkeyStr,
 		Signature: hex.EncodeToString(ed25519.Sign(privkey, append(pubkey, []byte("Test body")...))),
 	}
-	err := addStatusUpdate(&update)
+	err := srv.store.AddStatusUpdate(&update)
 	assert.NoError(t, err)
 
 	req, err := http.NewRequest("GET", "/status/"+pubkeyStr, nil)
 	assert.NoError(t, err)
 
 	rr := httptest.NewRecorder()
-	router := setupRouter()
+	router := srv.setupRouter()
 	router.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusOK, rr.Code)
@@ -177,8 +298,8 @@ func TestGetStatusUpdatesByPubkey(t *testing.T) {
 }
 
 func TestGetAllStatusUpdates(t *testing.T) {
-	setup()
-	defer teardown()
+	t.Parallel()
+	srv := newTestServer(t)
 
 	// Add a status update to the database
 	update := StatusUpdate{
@@ -187,14 +308,14 @@ func TestGetAllStatusUpdates(t *testing.T) {
 		Pubkey:    strings.Repeat("a", PubkeyMaxSize*2),
 		Signature: strings.Repeat("b", SignatureMaxSize*2),
 	}
-	err := addStatusUpdate(&update)
+	err := srv.store.AddStatusUpdate(&update)
 	assert.NoError(t, err)
 
 	req, err := http.NewRequest("GET", "/status", nil)
 	assert.NoError(t, err)
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(getAllStatusUpdates)
+	handler := http.HandlerFunc(srv.getAllStatusUpdates)
 
 	handler.ServeHTTP(rr, req)
 
@@ -207,8 +328,8 @@ func TestGetAllStatusUpdates(t *testing.T) {
 }
 
 func TestGetStatistics(t *testing.T) {
-	setup()
-	defer teardown()
+	t.Parallel()
+	srv := newTestServer(t)
 
 	// Add a status update to the database
 	update := StatusUpdate{
@@ -217,7 +338,7 @@ func TestGetStatistics(t *testing.T) {
 		Pubkey:    strings.Repeat("a", PubkeyMaxSize*2),
 		Signature: strings.Repeat("b", SignatureMaxSize*2),
 	}
-	err := addStatusUpdate(&update)
+	err := srv.store.AddStatusUpdate(&update)
 	assert.NoError(t, err)
 
 	// Insert initial statistics
@@ -233,14 +354,14 @@ func TestGetStatistics(t *testing.T) {
 		OldestPostTimestamp:        update.Timestamp,
 		RateLimitRequestsPerSecond: 1,
 	}
-	err = updateStatisticsInDB(initialStats)
+	err = srv.store.UpdateStatistics(initialStats)
 	assert.NoError(t, err)
 
 	req, err := http.NewRequest("GET", "/stats", nil)
 	assert.NoError(t, err)
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(getStatisticsHandler)
+	handler := http.HandlerFunc(srv.getStatisticsHandler)
 
 	handler.ServeHTTP(rr, req)
 
@@ -260,59 +381,51 @@ func TestGetStatistics(t *testing.T) {
 	assert.Equal(t, 1, stats.RateLimitRequestsPerSecond)
 }
 
-func TestPrintLiveStats(t *testing.T) {
-	setup()
-	defer teardown()
+func TestRecordStats(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
 
-	// Redirect stdout to capture output
-	old := os.Stdout
-	r, w, _ := os.Pipe()
-	os.Stdout = w
-
-	// Create a context with cancel to stop printLiveStats
+	// Create a context with cancel to stop recordStats
 	ctx, cancel := context.WithCancel(context.Background())
 
-	// Run printLiveStats in a goroutine
+	// Run recordStats in a goroutine
 	done := make(chan bool)
 	go func() {
-		printLiveStats(ctx)
+		srv.recordStats(ctx)
 		done <- true
 	}()
 
 	// Wait for one tick of the stats refresh
 	time.Sleep(StatsRefreshInterval + 100*time.Millisecond)
 
-	// Stop printLiveStats
+	// Stop recordStats
 	cancel()
 	<-done
 
-	// Restore stdout
-	w.Close()
-	os.Stdout = old
+	// An empty database is recorded too
+	stats, err := srv.store.GetLatestStatistics()
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.TotalPosts)
+	assert.Equal(t, 0.0, stats.AveragePostsPerPubkey)
+}
 
-	var buf bytes.Buffer
-	_, err := io.Copy(&buf, r)
-	assert.NoError(t, err)
+func TestDashboard(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+
+	req := httptest.NewRequest("GET", "/dashboard", nil)
+	rr := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rr, req)
 
-	output := buf.String()
-
-	// Check for expected output
-	assert.Contains(t, output, "Live Statistics:")
-	assert.Contains(t, output, "Total Posts:")
-	assert.Contains(t, output, "Unique Pubkeys:")
-	assert.Contains(t, output, "Successful Requests:")
-	assert.Contains(t, output, "Failed Requests:")
-	assert.Contains(t, output, "Total Requests:")
-	assert.Contains(t, output, "Avg. Per Pubkey:")
-	assert.Contains(t, output, "Most Recent Post Time:")
-	assert.Contains(t, output, "Oldest Post Time:")
-	assert.Contains(t, output, "Limit (reqs/second):")
-	assert.Contains(t, output, "Top Prolific Pubkeys:")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Header().Get("Content-Security-Policy"), "connect-src 'self'")
+	assert.Contains(t, rr.Body.String(), `fetch("stats"`)
 }
 
 func TestUpdateStatisticsInDB(t *testing.T) {
-	setup()
-	defer teardown()
+	t.Parallel()
+	srv := newTestServer(t)
 
 	stats := Statistics{
 		Timestamp:                  time.Now().Unix(),
@@ -327,10 +440,10 @@ func TestUpdateStatisticsInDB(t *testing.T) {
 		RateLimitRequestsPerSecond: 1,
 	}
 
-	err := updateStatisticsInDB(stats)
+	err := srv.store.UpdateStatistics(stats)
 	assert.NoError(t, err)
 
-	retrievedStats, err := getLatestStatisticsFromDB()
+	retrievedStats, err := srv.store.GetLatestStatistics()
 	assert.NoError(t, err)
 
 	assert.Equal(t, stats.TotalPosts, retrievedStats.TotalPosts)
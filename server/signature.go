@@ -0,0 +1,262 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/donuts-are-good/postshortly/sdk"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// SignedRequestMaxSkew is how far the timestamp of a signed request may
+	// be from the server's clock.
+	SignedRequestMaxSkew = 5 * time.Minute
+	// SignedRequestMaxSize bounds the body of a signed request, which is
+	// read in full to check its hash. Media uploads may be as large as
+	// MediaConfig.MaxSize instead.
+	SignedRequestMaxSize = 64 * 1024
+	// SeenNoncesMax bounds how many nonces are remembered to refuse
+	// replayed requests. Once it is reached, signed requests are refused
+	// until some expire: forgetting a nonce early would let it be replayed.
+	SeenNoncesMax = 100000
+	// SeenNoncesPerKeyMax bounds how many nonces one key may have
+	// remembered at a time, so a single key cannot push out everyone
+	// else's.
+	SeenNoncesPerKeyMax = 1000
+)
+
+var (
+	errSignedRequestTooLarge = errors.New("request body is too large")
+	errSignedRequestReplayed = errors.New("request was already received")
+	errTooManySignedRequests = errors.New("too many signed requests")
+	errNonceCacheFull        = errors.New("too many signed requests in flight")
+)
+
+type signerKey struct{}
+
+// signedRequest is what signatureMiddleware learned from a request that
+// verified. The nonce is only remembered once a handler uses the signer.
+type signedRequest struct {
+	pubkey   string
+	nonce    string
+	expires  time.Time
+	recorded bool
+}
+
+// signer returns the hex public key that signed r, or "" for requests
+// that were not signed. It is set by signatureMiddleware.
+func signer(r *http.Request) string {
+	if signed, ok := r.Context().Value(signerKey{}).(*signedRequest); ok {
+		return signed.pubkey
+	}
+	return ""
+}
+
+// isSignedRequest reports whether r carries the signed request headers.
+func isSignedRequest(r *http.Request) bool {
+	return r.Header.Get(sdk.RequestKeyHeader) != ""
+}
+
+// signatureMiddleware verifies every signed request and records who signed
+// it for the handlers. A signature that does not verify fails the request
+// even where signing is optional. Unsigned requests pass through; handlers
+// that need a signer use requireSignature, and those that merely accept one
+// call recordSigner.
+func (s *Server) signatureMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isSignedRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		signed, err := s.verifySignedRequest(r)
+		if err != nil {
+			s.handleSignedRequestError(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), signerKey{}, signed)))
+	})
+}
+
+// requireSignature only lets signed requests through.
+func (s *Server) requireSignature(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if signer(r) == "" {
+			w.Header().Set("WWW-Authenticate", "Postshortly-Signature")
+			s.handleError(w, r, CodeUnauthorized, "Unauthorized: a signed request is required", http.StatusUnauthorized)
+			return
+		}
+		if !s.recordSigner(w, r) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// recordSigner remembers the nonce of a signed request so it cannot be
+// sent again. Replays are harmless where the signer is ignored, so only
+// handlers that act on it pay for the cache. It answers r and returns false
+// if the request must be refused; unsigned requests are let through.
+func (s *Server) recordSigner(w http.ResponseWriter, r *http.Request) bool {
+	signed, ok := r.Context().Value(signerKey{}).(*signedRequest)
+	if !ok || signed.recorded {
+		return true
+	}
+	if err := s.seenNonces.add(signed.pubkey, signed.nonce, signed.expires); err != nil {
+		s.handleSignedRequestError(w, r, err)
+		return false
+	}
+	signed.recorded = true
+	return true
+}
+
+// verifySignedRequest checks the signature headers of r and returns who
+// signed it. The body is read to check its hash and put back for the
+// handler.
+func (s *Server) verifySignedRequest(r *http.Request) (*signedRequest, error) {
+	pubkey := strings.ToLower(r.Header.Get(sdk.RequestKeyHeader))
+	key, err := hex.DecodeString(pubkey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid key")
+	}
+
+	timestamp, err := strconv.ParseInt(r.Header.Get(sdk.RequestTimestampHeader), 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid timestamp")
+	}
+	if skew := time.Since(time.Unix(timestamp, 0)); skew > SignedRequestMaxSkew || skew < -SignedRequestMaxSkew {
+		return nil, errors.New("timestamp out of range")
+	}
+
+	nonce := r.Header.Get(sdk.RequestNonceHeader)
+	if nonce == "" || len(nonce) > sdk.RequestNonceMaxSize {
+		return nil, errors.New("invalid nonce")
+	}
+
+	signature, err := hex.DecodeString(r.Header.Get(sdk.RequestSignatureHeader))
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return nil, errors.New("invalid signature")
+	}
+
+	limit := int64(SignedRequestMaxSize)
+	if route := mux.CurrentRoute(r); route != nil && route.GetName() == uploadMediaRoute {
+		limit = max(limit, s.config.Media.MaxSize)
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, errors.New("error reading body")
+	}
+	if int64(len(body)) > limit {
+		return nil, errSignedRequestTooLarge
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	if !ed25519.Verify(key, sdk.RequestMessage(r.Method, r.Host, r.URL.RequestURI(), timestamp, nonce, body), signature) {
+		return nil, errors.New("signature verification failed")
+	}
+
+	// Only requests that verified get their nonce remembered, so nobody
+	// can use up someone else's.
+	return &signedRequest{pubkey: pubkey, nonce: nonce, expires: time.Unix(timestamp, 0).Add(SignedRequestMaxSkew)}, nil
+}
+
+// handleSignedRequestError answers a request whose signature headers did
+// not verify.
+func (s *Server) handleSignedRequestError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errSignedRequestTooLarge):
+		s.handleError(w, r, CodePayloadTooLarge, "Request body is too large", http.StatusRequestEntityTooLarge)
+	case errors.Is(err, errTooManySignedRequests):
+		w.Header().Set("Retry-After", strconv.Itoa(int(SignedRequestMaxSkew.Seconds())))
+		s.handleError(w, r, CodeRateLimited, "Too many signed requests from this key", http.StatusTooManyRequests)
+	case errors.Is(err, errNonceCacheFull):
+		w.Header().Set("Retry-After", "60")
+		s.handleError(w, r, CodeRateLimited, "Too many signed requests", http.StatusServiceUnavailable)
+	default:
+		w.Header().Set("WWW-Authenticate", "Postshortly-Signature")
+		s.handleError(w, r, CodeUnauthorized, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+	}
+}
+
+// nonceCache remembers the nonces of signed requests until their
+// timestamps fall out of the accepted window, after which a replay would be
+// refused anyway. Each key may hold SeenNoncesPerKeyMax of them, and no
+// more than SeenNoncesMax are held in all.
+type nonceCache struct {
+	mu      sync.Mutex
+	expires map[nonceID]time.Time
+	perKey  map[string]int
+	order   []nonceEntry
+}
+
+type nonceID struct {
+	pubkey, nonce string
+}
+
+type nonceEntry struct {
+	id      nonceID
+	expires time.Time
+}
+
+func (c *nonceCache) add(pubkey, nonce string, expires time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.expires == nil {
+		c.expires = map[nonceID]time.Time{}
+		c.perKey = map[string]int{}
+	}
+	id := nonceID{pubkey, nonce}
+	exp, seen := c.expires[id]
+	if seen && exp.After(now) {
+		return errSignedRequestReplayed
+	}
+
+	// Entries are kept in the order they were added, which is close to the
+	// order they expire in.
+	for len(c.order) > 0 && !c.order[0].expires.After(now) {
+		c.forget(c.order[0])
+		c.order = c.order[1:]
+	}
+	if _, seen = c.expires[id]; !seen {
+		if c.perKey[pubkey] >= SeenNoncesPerKeyMax {
+			return errTooManySignedRequests
+		}
+		if len(c.expires) >= SeenNoncesMax {
+			// Some later entries may have expired before the first.
+			for id, exp := range c.expires {
+				if !exp.After(now) {
+					c.forget(nonceEntry{id, exp})
+				}
+			}
+			if len(c.expires) >= SeenNoncesMax {
+				return errNonceCacheFull
+			}
+		}
+		c.perKey[pubkey]++
+	}
+	c.expires[id] = expires
+	c.order = append(c.order, nonceEntry{id, expires})
+	return nil
+}
+
+// forget drops e unless its nonce was added again since.
+func (c *nonceCache) forget(e nonceEntry) {
+	if exp, ok := c.expires[e.id]; !ok || !exp.Equal(e.expires) {
+		return
+	}
+	delete(c.expires, e.id)
+	if c.perKey[e.id.pubkey]--; c.perKey[e.id.pubkey] == 0 {
+		delete(c.perKey, e.id.pubkey)
+	}
+}
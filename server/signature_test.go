@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/donuts-are-good/postshortly/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignatureMiddleware(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	pub, priv, _ := sdk.GenerateKey()
+
+	var seen string
+	r := srv.setupRouter()
+	r.HandleFunc("/whoami", srv.requireSignature(func(w http.ResponseWriter, r *http.Request) {
+		seen = signer(r)
+		fmt.Fprint(w, "ok")
+	})).Methods("POST")
+
+	signed := func() *http.Request {
+		req := httptest.NewRequest("POST", "/whoami?x=1", strings.NewReader("hello"))
+		require.NoError(t, sdk.SignRequest(req, priv))
+		return req
+	}
+
+	req := signed()
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, hex.EncodeToString(pub), seen)
+
+	// The same request sent again is refused.
+	replay := httptest.NewRequest("POST", "/whoami?x=1", strings.NewReader("hello"))
+	replay.Header = req.Header.Clone()
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, replay)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Contains(t, rr.Body.String(), "already received")
+
+	// A new signature of the same request is fine.
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, signed())
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("POST", "/whoami", nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, "Postshortly-Signature", rr.Header().Get("WWW-Authenticate"))
+
+	// Replays are only refused where the signer is used, so routes that
+	// ignore it don't fill the cache.
+	req = httptest.NewRequest("GET", "/status", nil)
+	require.NoError(t, sdk.SignRequest(req, priv))
+	for i := 0; i < 2; i++ {
+		rr = httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+	assert.Equal(t, 2, srv.seenNonces.perKey[hex.EncodeToString(pub)], "only the /whoami requests are remembered")
+
+	// The host is signed, so a request cannot be replayed to another instance.
+	req = signed()
+	req.Host = "elsewhere.example"
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// A bad signature fails a request even where signing is optional.
+	req = httptest.NewRequest("GET", "/status", nil)
+	require.NoError(t, sdk.SignRequest(req, priv))
+	req.Header.Set(sdk.RequestTimestampHeader, "1")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestClientSignsRequests(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	srv.config.Access.Mode = AccessPrivate
+	pub, priv, _ := sdk.GenerateKey()
+	require.NoError(t, srv.store.Moderate(&ModerationAction{Actor: "token", Action: ActionAllow, Target: hex.EncodeToString(pub)}))
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+
+	client := sdk.NewClient(ts.URL)
+	_, err := client.Feed(context.Background())
+	assert.Error(t, err)
+
+	client.Key = priv
+	for i := 0; i < 3; i++ {
+		_, err = client.Feed(context.Background())
+		require.NoError(t, err)
+	}
+}
+
+func TestNonceCache(t *testing.T) {
+	t.Parallel()
+	var c nonceCache
+	future := time.Now().Add(time.Minute)
+
+	require.NoError(t, c.add("k", "a", future))
+	assert.ErrorIs(t, c.add("k", "a", future), errSignedRequestReplayed)
+	require.NoError(t, c.add("other", "a", future), "nonces are per key")
+	require.NoError(t, c.add("k", "b", time.Now().Add(-time.Second)))
+	require.NoError(t, c.add("k", "b", future), "expired nonces are forgotten")
+
+	// One key cannot hold more than its share.
+	for i := c.perKey["k"]; i < SeenNoncesPerKeyMax; i++ {
+		require.NoError(t, c.add("k", fmt.Sprint(i), future))
+	}
+	assert.ErrorIs(t, c.add("k", "c", future), errTooManySignedRequests)
+	require.NoError(t, c.add("other", "c", future))
+
+	// When full, new nonces are refused rather than forgetting ones that
+	// could still be replayed.
+	for i := 0; len(c.expires) < SeenNoncesMax; i++ {
+		require.NoError(t, c.add(fmt.Sprint("key", i), "n", future))
+	}
+	assert.ErrorIs(t, c.add("new", "n", future), errNonceCacheFull)
+	assert.ErrorIs(t, c.add("k", "a", future), errSignedRequestReplayed, "the oldest nonce is still remembered")
+	assert.Equal(t, SeenNoncesPerKeyMax, c.perKey["k"])
+
+	// Expired nonces make room wherever they are.
+	c.expires[nonceID{"key5", "n"}] = time.Now().Add(-time.Second)
+	require.NoError(t, c.add("new", "n", future))
+}
@@ -1,8 +1,7 @@
-package main
+package server
 
 import (
 	"context"
-	"fmt"
 	"sort"
 	"time"
 
@@ -33,8 +32,8 @@ type ProlificPubkey struct {
 	Count  int    `json:"count"`
 }
 
-func getStatistics(successfulRequests, failedRequests int, limiter *rate.Limiter) (Statistics, error) {
-	allUpdates, err := getAllStatusUpdatesFromDB()
+func getStatistics(store Store, successfulRequests, failedRequests int, limiter *rate.Limiter) (Statistics, error) {
+	allUpdates, err := store.GetAllStatusUpdates()
 	if err != nil {
 		return Statistics{}, err
 	}
@@ -47,7 +46,10 @@ func getStatistics(successfulRequests, failedRequests int, limiter *rate.Limiter
 	uniquePubkeys := len(pubkeyPostCounts)
 	topProlificPubkeys := getTopProlificPubkeys(pubkeyPostCounts)
 	totalRequests := successfulRequests + failedRequests
-	averagePostsPerPubkey := float64(len(allUpdates)) / float64(uniquePubkeys)
+	var averagePostsPerPubkey float64
+	if uniquePubkeys > 0 {
+		averagePostsPerPubkey = float64(len(allUpdates)) / float64(uniquePubkeys)
+	}
 	var mostRecentPostTimestamp, oldestPostTimestamp int64
 	if len(allUpdates) > 0 {
 		mostRecentPostTimestamp = allUpdates[0].Timestamp
@@ -74,7 +76,9 @@ func getStatistics(successfulRequests, failedRequests int, limiter *rate.Limiter
 	return stats, nil
 }
 
-func printLiveStats(ctx context.Context) {
+// recordStats stores a snapshot of the statistics every
+// StatsRefreshInterval, for GET /stats and the dashboard.
+func (s *Server) recordStats(ctx context.Context) {
 	ticker := time.NewTicker(StatsRefreshInterval)
 	defer ticker.Stop()
 
@@ -83,36 +87,15 @@ func printLiveStats(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			stats, err := getStatistics(successfulRequests, failedRequests, limiter)
+			stats, err := getStatistics(s.store, int(s.metrics.successfulRequests.Load()), int(s.metrics.failedRequests.Load()), s.limiter)
 			if err != nil {
-				fmt.Printf("Error getting statistics: %v\n", err)
+				s.log.Error("error getting statistics", "error", err)
 				continue
 			}
 
-			// Update statistics in the database
-			err = updateStatisticsInDB(stats)
-			if err != nil {
-				fmt.Printf("Error updating statistics in database: %v\n", err)
-			}
-
-			// Clear the screen and move cursor to top-left
-			fmt.Print("\033[2J\033[H")
-
-			// Print underlined "Live Statistics:"
-			fmt.Println("\033[4mLive Statistics:\033[0m")
-			fmt.Printf("-> Total Posts:           %d\n", stats.TotalPosts)
-			fmt.Printf("-> Unique Pubkeys:        %d\n", stats.UniquePubkeys)
-			fmt.Printf("-> Successful Requests:   %d\n", stats.SuccessfulRequests)
-			fmt.Printf("-> Failed Requests:       %d\n", stats.FailedRequests)
-			fmt.Printf("-> Total Requests:        %d\n", stats.TotalRequests)
-			fmt.Printf("-> Avg. Per Pubkey:       %.2f\n", stats.AveragePostsPerPubkey)
-			fmt.Printf("-> Most Recent Post Time: %s\n", time.Unix(0, stats.MostRecentPostTimestamp).Format("2006-01-02 03:04:05 PM"))
-			fmt.Printf("-> Oldest Post Time:      %s\n", time.Unix(0, stats.OldestPostTimestamp).Format("2006-01-02 03:04:05 PM"))
-			fmt.Printf("-> Limit (reqs/second):   %d\n", stats.RateLimitRequestsPerSecond)
-
-			fmt.Println("\nTop Prolific Pubkeys:")
-			for i, pubkey := range stats.TopProlificPubkeys {
-				fmt.Printf("%d. %s: %d posts\n", i+1, pubkey.Pubkey, pubkey.Count)
+			if err := s.store.UpdateStatistics(stats); err != nil {
+				s.log.Error("error updating statistics", "error", err)
+				continue
 			}
 		}
 	}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/donuts-are-good/postshortly/sdk"
+)
+
+// SyncResult summarises one pass over a peer's feed.
+type SyncResult struct {
+	Fetched    int
+	Stored     int
+	Duplicates int
+	Rejected   int
+}
+
+// runPeerSync polls every configured peer until ctx is cancelled.
+func (s *Server) runPeerSync(ctx context.Context) {
+	if len(s.config.Peers) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(s.config.SyncInterval))
+	defer ticker.Stop()
+
+	for {
+		for _, peer := range s.config.Peers {
+			if _, err := s.syncPeer(ctx, sdk.NewClient(peer)); err != nil {
+				s.log.Error("error syncing peer", "peer", peer, "error", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncPeer pulls every post the peer has published since the stored cursor.
+// Posts are verified and checked like a POST /status request and
+// deduplicated by pubkey and signature, so posts that travel back and forth
+// between instances are stored once. The cursor only advances past pages
+// that were fully processed.
+func (s *Server) syncPeer(ctx context.Context, client *sdk.Client) (SyncResult, error) {
+	var result SyncResult
+	peer := client.BaseURL
+
+	cursor, err := s.store.GetPeerCursor(peer)
+	if err != nil {
+		return result, err
+	}
+
+	for {
+		page, err := client.FeedSince(ctx, cursor, FeedPageSize)
+		if err != nil {
+			return result, err
+		}
+
+		for _, post := range page {
+			result.Fetched++
+			if err := s.storePeerPost(peer, post, &result); err != nil {
+				return result, err
+			}
+			if post.ID > cursor {
+				cursor = post.ID
+			}
+		}
+
+		if err := s.store.SetPeerCursor(peer, cursor); err != nil {
+			return result, err
+		}
+
+		if len(page) < FeedPageSize {
+			return result, nil
+		}
+	}
+}
+
+// storePeerPost stores a post fetched from peer. The origin the peer
+// claims is not covered by the signature, so it is replaced with the peer
+// the post was actually fetched from.
+func (s *Server) storePeerPost(peer string, post sdk.StatusUpdate, result *SyncResult) error {
+	update := fromSDKStatusUpdate(post)
+	update.Origin = strings.TrimRight(peer, "/")
+	canonicalizeStatusUpdate(&update)
+
+	if err := validateStatusUpdate(update, 0); err != nil {
+		result.Rejected++
+		return nil
+	}
+	if err := s.checkReceivedStatusUpdate(update); err != nil {
+		if _, ok := err.(*ValidationError); ok {
+			result.Rejected++
+			return nil
+		}
+		return err
+	}
+
+	if update.Timestamp == 0 {
+		update.Timestamp = time.Now().UnixNano()
+	}
+	err := s.store.AddStatusUpdate(&update)
+	if errors.Is(err, ErrDuplicateStatusUpdate) {
+		result.Duplicates++
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	result.Stored++
+	return nil
+}
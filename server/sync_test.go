@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/donuts-are-good/postshortly/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newPeer runs srv's HTTP API as a second in-process instance.
+func newPeer(t *testing.T, srv *Server) *httptest.Server {
+	peer := httptest.NewServer(srv.Handler())
+	t.Cleanup(peer.Close)
+	return peer
+}
+
+// addSignedPosts stores n freshly signed posts by one key in srv.
+func addSignedPosts(t *testing.T, srv *Server, n int) []StatusUpdate {
+	_, privkey, _ := sdk.GenerateKey()
+	var posts []StatusUpdate
+	for i := 0; i < n; i++ {
+		signed := sdk.StatusUpdate{Body: fmt.Sprintf("post %d", i)}
+		sdk.SignStatusUpdate(privkey, &signed)
+		post := fromSDKStatusUpdate(signed)
+		post.Timestamp = time.Now().UnixNano()
+		assert.NoError(t, srv.store.AddStatusUpdate(&post))
+		posts = append(posts, post)
+	}
+	return posts
+}
+
+func TestSyncPeer(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	remote := newTestServer(t)
+	peer := newPeer(t, remote)
+	client := sdk.NewClient(peer.URL)
+
+	posts := addSignedPosts(t, remote, FeedPageSize+20)
+	forged := addSignedPosts(t, newTestServer(t), 1)[0]
+	forged.Body = "forged"
+	assert.NoError(t, remote.store.AddStatusUpdate(&forged))
+
+	result, err := srv.syncPeer(context.Background(), client)
+	assert.NoError(t, err)
+	assert.Equal(t, FeedPageSize+21, result.Fetched)
+	assert.Equal(t, FeedPageSize+20, result.Stored)
+	assert.Equal(t, 1, result.Rejected)
+
+	cursor, err := srv.store.GetPeerCursor(peer.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, forged.ID, cursor)
+
+	updates, err := srv.store.GetAllStatusUpdates()
+	assert.NoError(t, err)
+	assert.Len(t, updates, FeedPageSize+20)
+	assert.Equal(t, peer.URL, updates[0].Origin)
+	assert.Equal(t, posts[len(posts)-1].Timestamp, updates[0].Timestamp)
+
+	// Nothing new: the cursor keeps the peer from resending anything.
+	result, err = srv.syncPeer(context.Background(), client)
+	assert.NoError(t, err)
+	assert.Zero(t, result.Fetched)
+
+	// A post the peer claims came from somewhere else is recorded as coming
+	// from the peer, and one we already got from elsewhere too is skipped.
+	relayed := addSignedPosts(t, newTestServer(t), 2)
+	relayed[0].Origin = "https://elsewhere.example"
+	assert.NoError(t, remote.store.AddStatusUpdate(&relayed[0]))
+	assert.NoError(t, remote.store.AddStatusUpdate(&relayed[1]))
+	assert.NoError(t, srv.store.AddStatusUpdate(&relayed[1]))
+
+	result, err = srv.syncPeer(context.Background(), client)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Stored)
+	assert.Equal(t, 1, result.Duplicates)
+
+	stored, err := srv.store.GetStatusUpdateBySignature(relayed[0].Signature)
+	assert.NoError(t, err)
+	assert.Equal(t, peer.URL, stored.Origin)
+}
+
+func TestSyncBetweenInstances(t *testing.T) {
+	t.Parallel()
+	a, b := newTestServer(t), newTestServer(t)
+	peerA, peerB := newPeer(t, a), newPeer(t, b)
+
+	addSignedPosts(t, a, 2)
+	addSignedPosts(t, b, 3)
+
+	// Following each other both ways settles once every post is stored on
+	// both sides, without posts bouncing back as new copies.
+	for i := 0; i < 2; i++ {
+		_, err := a.syncPeer(context.Background(), sdk.NewClient(peerB.URL))
+		assert.NoError(t, err)
+		_, err = b.syncPeer(context.Background(), sdk.NewClient(peerA.URL))
+		assert.NoError(t, err)
+	}
+
+	for _, srv := range []*Server{a, b} {
+		updates, err := srv.store.GetAllStatusUpdates()
+		assert.NoError(t, err)
+		assert.Len(t, updates, 5)
+	}
+
+	updates, err := b.store.GetStatusUpdatesSince(3, FeedMaxPageSize)
+	assert.NoError(t, err)
+	for _, update := range updates {
+		assert.Equal(t, peerA.URL, update.Origin)
+	}
+}
+
+func TestGetStatusUpdatesSince(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	addSignedPosts(t, srv, 3)
+
+	router := srv.setupRouter()
+
+	req, _ := http.NewRequest("GET", "/status?since=1&limit=1", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var updates []StatusUpdate
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&updates))
+	assert.Len(t, updates, 1)
+	assert.Equal(t, 2, updates[0].ID)
+
+	for _, query := range []string{"since=-1", "since=abc", "since=0&limit=0", fmt.Sprintf("since=0&limit=%d", FeedMaxPageSize+1)} {
+		req, _ := http.NewRequest("GET", "/status?"+query, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, query)
+	}
+}
+
+func TestSyncPeerChecksAccess(t *testing.T) {
+	t.Parallel()
+	store, err := OpenMemoryStore()
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	cfg := DefaultConfig()
+	cfg.Access.Mode = AccessAllowlist
+	cfg.Filters.BlockedWords = []string{"spam"}
+	srv, err := New(cfg, store)
+	require.NoError(t, err)
+
+	remote := newTestServer(t)
+	_, member, _ := sdk.GenerateKey()
+	_, stranger, _ := sdk.GenerateKey()
+	for _, post := range []struct {
+		key ed25519.PrivateKey
+		sdk.StatusUpdate
+	}{
+		{member, sdk.StatusUpdate{Body: "hello"}},
+		{member, sdk.StatusUpdate{Body: "buy spam"}},
+		{member, sdk.StatusUpdate{Body: "look", Attachments: []string{strings.Repeat("ab", 32)}}},
+		{stranger, sdk.StatusUpdate{Body: "let me in"}},
+	} {
+		sdk.SignStatusUpdate(post.key, &post.StatusUpdate)
+		update := fromSDKStatusUpdate(post.StatusUpdate)
+		update.Timestamp = time.Now().UnixNano()
+		require.NoError(t, remote.store.AddStatusUpdate(&update))
+	}
+	memberKey := hex.EncodeToString(member.Public().(ed25519.PublicKey))
+	require.NoError(t, srv.store.Moderate(&ModerationAction{Actor: "token", Action: ActionAllow, Target: memberKey}))
+
+	result, err := srv.syncPeer(context.Background(), sdk.NewClient(newPeer(t, remote).URL))
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Stored)
+	assert.Equal(t, 3, result.Rejected)
+
+	updates, err := srv.store.GetAllStatusUpdates()
+	require.NoError(t, err)
+	require.Len(t, updates, 1)
+	assert.Equal(t, "hello", updates[0].Body)
+}
@@ -0,0 +1,365 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+
+	WebhookPollInterval  = time.Second
+	WebhookTimeout       = 10 * time.Second
+	WebhookBatchSize     = 50
+	WebhookWorkers       = 8
+	WebhookMaxAttempts   = 8
+	WebhookRetryBase     = 10 * time.Second
+	WebhookRetryMax      = time.Hour
+	WebhookDeliveriesLog = 100
+
+	// WebhookClaimLease keeps claimed deliveries from being claimed again
+	// while the batch is still being sent.
+	WebhookClaimLease = WebhookBatchSize * WebhookTimeout
+
+	WebhookEventHeader     = "X-Postshortly-Event"
+	WebhookDeliveryHeader  = "X-Postshortly-Delivery"
+	WebhookSignatureHeader = "X-Postshortly-Signature"
+)
+
+type Webhook struct {
+	ID      int    `json:"id"`
+	URL     string `json:"url"`
+	Secret  string `json:"secret,omitempty"`
+	Pubkey  string `json:"pubkey,omitempty"`
+	Tag     string `json:"tag,omitempty"`
+	Created int64  `json:"created"`
+}
+
+type WebhookDelivery struct {
+	ID           int    `json:"id" db:"id"`
+	WebhookID    int    `json:"webhook_id" db:"webhook_id"`
+	PostID       int    `json:"post_id" db:"post_id"`
+	Payload      string `json:"-" db:"payload"`
+	Status       string `json:"status" db:"status"`
+	Attempts     int    `json:"attempts" db:"attempts"`
+	NextAttempt  int64  `json:"next_attempt" db:"next_attempt"`
+	ResponseCode int    `json:"response_code" db:"response_code"`
+	LastError    string `json:"last_error,omitempty" db:"last_error"`
+	Created      int64  `json:"created" db:"created"`
+	Updated      int64  `json:"updated" db:"updated"`
+}
+
+type webhookEvent struct {
+	Event string       `json:"event"`
+	Post  StatusUpdate `json:"post"`
+}
+
+// Matches reports whether a post passes the webhook's pubkey and tag
+// filters. An empty filter matches everything.
+func (hook Webhook) Matches(update StatusUpdate) bool {
+	if hook.Pubkey != "" && hook.Pubkey != update.Pubkey {
+		return false
+	}
+	if hook.Tag == "" {
+		return true
+	}
+	for _, tag := range postTags(update.Body) {
+		if tag == hook.Tag {
+			return true
+		}
+	}
+	return false
+}
+
+// postTags returns the lower-cased #hashtags in a post body, without the #.
+func postTags(body string) []string {
+	var tags []string
+	for _, word := range strings.FieldsFunc(body, unicode.IsSpace) {
+		if !strings.HasPrefix(word, "#") {
+			continue
+		}
+		tag := strings.TrimRightFunc(word[1:], func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '_'
+		})
+		if tag != "" {
+			tags = append(tags, strings.ToLower(tag))
+		}
+	}
+	return tags
+}
+
+func webhookPayload(update StatusUpdate) (string, error) {
+	payload, err := json.Marshal(webhookEvent{Event: "status.created", Post: update})
+	return string(payload), err
+}
+
+// signWebhookPayload returns the value of the signature header: the
+// hex HMAC-SHA256 of the payload keyed with the webhook secret.
+func signWebhookPayload(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookRetryDelay doubles the wait after every failed attempt.
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := WebhookRetryBase
+	for i := 1; i < attempts && delay < WebhookRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > WebhookRetryMax {
+		delay = WebhookRetryMax
+	}
+	return delay
+}
+
+// runWebhookDeliveries works through the delivery queue until ctx is
+// cancelled.
+func (s *Server) runWebhookDeliveries(ctx context.Context) {
+	ticker := time.NewTicker(WebhookPollInterval)
+	defer ticker.Stop()
+
+	client := &http.Client{Timeout: WebhookTimeout}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := deliverDueWebhooks(ctx, s.store, client, time.Now()); err != nil {
+				s.log.Error("error delivering webhooks", "error", err)
+			}
+		}
+	}
+}
+
+// deliverDueWebhooks claims a batch of due deliveries and sends them, each
+// webhook's in order on its own worker, so a slow or dead target only holds
+// up its own queue.
+func deliverDueWebhooks(ctx context.Context, store Store, client *http.Client, now time.Time) error {
+	deliveries, err := store.ClaimWebhookDeliveries(now, WebhookClaimLease, WebhookBatchSize)
+	if err != nil {
+		return err
+	}
+
+	var hooks []int
+	queues := make(map[int][]WebhookDelivery)
+	for _, delivery := range deliveries {
+		if _, ok := queues[delivery.WebhookID]; !ok {
+			hooks = append(hooks, delivery.WebhookID)
+		}
+		queues[delivery.WebhookID] = append(queues[delivery.WebhookID], delivery)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	workers := make(chan struct{}, WebhookWorkers)
+	for _, id := range hooks {
+		workers <- struct{}{}
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			if err := deliverWebhookQueue(ctx, store, client, id, queues[id], now); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(id)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// deliverWebhookQueue sends one webhook's claimed deliveries in order. After
+// a failed attempt the rest are put back until the failed one is retried,
+// rather than each waiting out the timeout against the same target.
+func deliverWebhookQueue(ctx context.Context, store Store, client *http.Client, hookID int, queue []WebhookDelivery, now time.Time) error {
+	hook, err := store.GetWebhook(hookID)
+	if err == sql.ErrNoRows {
+		for _, delivery := range queue {
+			delivery.Status = DeliveryFailed
+			delivery.LastError = "webhook deleted"
+			if err := store.UpdateWebhookDelivery(delivery); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for i, delivery := range queue {
+		delivery.Attempts++
+		delivery.ResponseCode, err = sendWebhook(ctx, client, hook, delivery)
+		switch {
+		case err == nil:
+			delivery.Status = DeliveryDelivered
+			delivery.LastError = ""
+		case delivery.Attempts >= WebhookMaxAttempts:
+			delivery.Status = DeliveryFailed
+			delivery.LastError = err.Error()
+		default:
+			delivery.NextAttempt = now.Add(webhookRetryDelay(delivery.Attempts)).Unix()
+			delivery.LastError = err.Error()
+		}
+
+		if err := store.UpdateWebhookDelivery(delivery); err != nil {
+			return err
+		}
+		if delivery.Status == DeliveryDelivered {
+			continue
+		}
+
+		next := delivery.NextAttempt
+		if delivery.Status != DeliveryPending {
+			next = now.Add(WebhookRetryBase).Unix()
+		}
+		for _, rest := range queue[i+1:] {
+			rest.NextAttempt = next
+			if err := store.UpdateWebhookDelivery(rest); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+func sendWebhook(ctx context.Context, client *http.Client, hook Webhook, delivery WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, "status.created")
+	req.Header.Set(WebhookDeliveryHeader, strconv.Itoa(delivery.ID))
+	req.Header.Set(WebhookSignatureHeader, signWebhookPayload(hook.Secret, delivery.Payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("target returned %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func (s *Server) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var hook Webhook
+	if !s.decodeJSON(w, r, &hook, WebhookRequestMaxSize) {
+		return
+	}
+
+	target, err := url.Parse(hook.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		s.writeError(w, r, ErrorResponse{Code: CodeInvalidParameter, Message: "url must be an absolute http or https URL", Field: "url"}, http.StatusBadRequest)
+		return
+	}
+	if hook.Pubkey != "" && len(hook.Pubkey) != PubkeyMaxSize*2 {
+		s.writeError(w, r, ErrorResponse{Code: CodeInvalidPubkey, Message: "invalid pubkey length", Field: "pubkey"}, http.StatusBadRequest)
+		return
+	}
+	hook.Pubkey = strings.ToLower(hook.Pubkey)
+	hook.Tag = strings.ToLower(strings.TrimPrefix(hook.Tag, "#"))
+
+	if hook.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			s.handleError(w, r, CodeInternal, "Error generating webhook secret", http.StatusInternalServerError)
+			return
+		}
+		hook.Secret = hex.EncodeToString(secret)
+	}
+
+	if err := s.store.AddWebhook(&hook); err != nil {
+		s.handleError(w, r, CodeInternal, "Error adding webhook", http.StatusInternalServerError)
+		return
+	}
+
+	// The secret is only ever shown in this response.
+	s.render(w, r, http.StatusCreated, hook)
+}
+
+func (s *Server) getWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := s.store.GetWebhooks()
+	if err != nil {
+		s.handleError(w, r, CodeInternal, "Error retrieving webhooks", http.StatusInternalServerError)
+		return
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+
+	s.render(w, r, http.StatusOK, webhooks)
+}
+
+func (s *Server) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		s.writeError(w, r, ErrorResponse{Code: CodeInvalidParameter, Message: "Invalid webhook id", Field: "id"}, http.StatusBadRequest)
+		return
+	}
+
+	found, err := s.store.DeleteWebhook(id)
+	if err != nil {
+		s.handleError(w, r, CodeInternal, "Error deleting webhook", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		s.handleError(w, r, CodeNotFound, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		s.writeError(w, r, ErrorResponse{Code: CodeInvalidParameter, Message: "Invalid webhook id", Field: "id"}, http.StatusBadRequest)
+		return
+	}
+
+	if _, err := s.store.GetWebhook(id); err == sql.ErrNoRows {
+		s.handleError(w, r, CodeNotFound, "Webhook not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		s.handleError(w, r, CodeInternal, "Error retrieving webhook", http.StatusInternalServerError)
+		return
+	}
+
+	deliveries, err := s.store.GetWebhookDeliveries(id, WebhookDeliveriesLog)
+	if err != nil {
+		s.handleError(w, r, CodeInternal, "Error retrieving deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	s.render(w, r, http.StatusOK, deliveries)
+}
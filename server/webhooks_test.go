@@ -0,0 +1,278 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testAdminToken = "test-admin-token"
+
+func adminRequest(method, path string, body io.Reader) *http.Request {
+	req, _ := http.NewRequest(method, path, body)
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	return req
+}
+
+func registerWebhook(t *testing.T, srv *Server, hook Webhook) Webhook {
+	body, _ := json.Marshal(hook)
+	rr := httptest.NewRecorder()
+	srv.setupRouter().ServeHTTP(rr, adminRequest("POST", "/webhooks", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	var created Webhook
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&created))
+	return created
+}
+
+// testPost returns an unsigned post whose made-up signature is unique to
+// its body.
+func testPost(pubkey, body string) StatusUpdate {
+	signature := sha512.Sum512([]byte(body))
+	return StatusUpdate{
+		Timestamp: time.Now().UnixNano(),
+		Body:      body,
+		Pubkey:    pubkey,
+		Signature: hex.EncodeToString(signature[:]),
+	}
+}
+
+func TestWebhookAdminAuth(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+
+	router := srv.setupRouter()
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest("GET", "/webhooks", nil))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	srv.config.AdminToken = testAdminToken
+
+	req, _ := http.NewRequest("GET", "/webhooks", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest("GET", "/webhooks", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	srv.config.AdminToken = testAdminToken
+
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	deliveries := make(chan received, 10)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- received{r.Header, body}
+	}))
+	defer target.Close()
+
+	pubkey := strings.Repeat("a", PubkeyMaxSize*2)
+	all := registerWebhook(t, srv, Webhook{URL: target.URL})
+	assert.NotEmpty(t, all.Secret)
+	byKey := registerWebhook(t, srv, Webhook{URL: target.URL, Pubkey: strings.Repeat("c", PubkeyMaxSize*2)})
+	byTag := registerWebhook(t, srv, Webhook{URL: target.URL, Tag: "#Release", Secret: "tag-secret"})
+	assert.Equal(t, "release", byTag.Tag)
+
+	update := testPost(pubkey, "Shipping v2 today #release!")
+	assert.NoError(t, srv.store.AddStatusUpdate(&update))
+
+	assert.NoError(t, deliverDueWebhooks(context.Background(), srv.store, http.DefaultClient, time.Now()))
+	assert.Len(t, deliveries, 2)
+
+	for i := 0; i < 2; i++ {
+		d := <-deliveries
+		secret := all.Secret
+		if d.header.Get(WebhookDeliveryHeader) != "1" {
+			secret = "tag-secret"
+		}
+		assert.Equal(t, signWebhookPayload(secret, string(d.body)), d.header.Get(WebhookSignatureHeader))
+		assert.Equal(t, "status.created", d.header.Get(WebhookEventHeader))
+
+		var event webhookEvent
+		assert.NoError(t, json.Unmarshal(d.body, &event))
+		assert.Equal(t, update.ID, event.Post.ID)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.setupRouter().ServeHTTP(rr, adminRequest("GET", fmt.Sprintf("/webhooks/%d/deliveries", all.ID), nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var log []WebhookDelivery
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&log))
+	assert.Len(t, log, 1)
+	assert.Equal(t, DeliveryDelivered, log[0].Status)
+	assert.Equal(t, http.StatusOK, log[0].ResponseCode)
+
+	log, err := srv.store.GetWebhookDeliveries(byKey.ID, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, log)
+}
+
+func TestWebhookRetries(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	srv.config.AdminToken = testAdminToken
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer target.Close()
+
+	hook := registerWebhook(t, srv, Webhook{URL: target.URL})
+	update := testPost(strings.Repeat("a", PubkeyMaxSize*2), "Test body")
+	assert.NoError(t, srv.store.AddStatusUpdate(&update))
+
+	now := time.Now()
+	for attempt := 1; attempt <= WebhookMaxAttempts; attempt++ {
+		assert.NoError(t, deliverDueWebhooks(context.Background(), srv.store, http.DefaultClient, now))
+
+		log, err := srv.store.GetWebhookDeliveries(hook.ID, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, attempt, log[0].Attempts)
+		assert.Equal(t, http.StatusServiceUnavailable, log[0].ResponseCode)
+
+		if attempt < WebhookMaxAttempts {
+			assert.Equal(t, DeliveryPending, log[0].Status)
+			assert.Equal(t, now.Add(webhookRetryDelay(attempt)).Unix(), log[0].NextAttempt)
+
+			// Not due again until the backoff has passed.
+			due, err := srv.store.GetDueWebhookDeliveries(now, 10)
+			assert.NoError(t, err)
+			assert.Empty(t, due)
+			now = now.Add(webhookRetryDelay(attempt))
+		} else {
+			assert.Equal(t, DeliveryFailed, log[0].Status)
+		}
+	}
+}
+
+func TestWebhookFailureHoldsQueue(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	srv.config.AdminToken = testAdminToken
+
+	var requests atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer target.Close()
+
+	hook := registerWebhook(t, srv, Webhook{URL: target.URL})
+	for i := 0; i < 3; i++ {
+		update := testPost(strings.Repeat("a", PubkeyMaxSize*2), fmt.Sprintf("post %d", i))
+		assert.NoError(t, srv.store.AddStatusUpdate(&update))
+	}
+
+	now := time.Now()
+	assert.NoError(t, deliverDueWebhooks(context.Background(), srv.store, http.DefaultClient, now))
+	assert.Equal(t, int32(1), requests.Load())
+
+	// The deliveries behind the failed one wait for its retry.
+	log, err := srv.store.GetWebhookDeliveries(hook.ID, 10)
+	assert.NoError(t, err)
+	assert.Len(t, log, 3)
+	for _, delivery := range log {
+		assert.Equal(t, DeliveryPending, delivery.Status)
+		assert.Equal(t, now.Add(webhookRetryDelay(1)).Unix(), delivery.NextAttempt)
+	}
+}
+
+func TestWebhookSlowTarget(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	srv.config.AdminToken = testAdminToken
+
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	delivered := make(chan struct{}, 1)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- struct{}{}
+	}))
+	defer fast.Close()
+
+	registerWebhook(t, srv, Webhook{URL: slow.URL})
+	registerWebhook(t, srv, Webhook{URL: fast.URL})
+	update := testPost(strings.Repeat("a", PubkeyMaxSize*2), "Test body")
+	assert.NoError(t, srv.store.AddStatusUpdate(&update))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- deliverDueWebhooks(context.Background(), srv.store, http.DefaultClient, time.Now())
+	}()
+
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("fast webhook waited for the slow one")
+	}
+
+	// Claimed deliveries aren't handed out again while they're being sent.
+	due, err := srv.store.ClaimWebhookDeliveries(time.Now(), WebhookClaimLease, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, due)
+
+	release <- struct{}{}
+	assert.NoError(t, <-done)
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	assert.Equal(t, WebhookRetryBase, webhookRetryDelay(1))
+	assert.Equal(t, 2*WebhookRetryBase, webhookRetryDelay(2))
+	assert.Equal(t, 4*WebhookRetryBase, webhookRetryDelay(3))
+	assert.Equal(t, WebhookRetryMax, webhookRetryDelay(100))
+}
+
+func TestDeleteWebhook(t *testing.T) {
+	t.Parallel()
+	srv := newTestServer(t)
+	srv.config.AdminToken = testAdminToken
+
+	hook := registerWebhook(t, srv, Webhook{URL: "http://example.com/hook"})
+	update := testPost(strings.Repeat("a", PubkeyMaxSize*2), "Test body")
+	assert.NoError(t, srv.store.AddStatusUpdate(&update))
+
+	router := srv.setupRouter()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest("DELETE", fmt.Sprintf("/webhooks/%d", hook.ID), nil))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	due, err := srv.store.GetDueWebhookDeliveries(time.Now(), 10)
+	assert.NoError(t, err)
+	assert.Empty(t, due)
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, adminRequest("DELETE", fmt.Sprintf("/webhooks/%d", hook.ID), nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestPostTags(t *testing.T) {
+	assert.Equal(t, []string{"go", "release_2"}, postTags("#Go is out, see #release_2. Not a#tag"))
+	assert.Empty(t, postTags("no tags # here"))
+}